@@ -0,0 +1,147 @@
+package acl
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/kv"
+	"google.golang.org/protobuf/proto"
+)
+
+// KeyPrefix starts the keys ACL rules are stored under
+const KeyPrefix = kv.ReservedPrefix + "acl/"
+
+// Wildcard is the subject of rules that apply to every subject
+const Wildcard = "*"
+
+// Key returns the key a rule is stored under
+func Key(subject, prefix string) string {
+	return KeyPrefix + subject + "\x00" + prefix
+}
+
+// Encode returns the record storing a rule
+func Encode(rule *api.ACLRule) (*api.Record, error) {
+	b, err := proto.Marshal(rule)
+	if err != nil {
+		return nil, err
+	}
+	return &api.Record{Id: Key(rule.Subject, rule.Prefix), Value: b}, nil
+}
+
+// Decode returns the rule stored in a record
+func Decode(record *api.Record) (*api.ACLRule, error) {
+	rule := &api.ACLRule{}
+	return rule, proto.Unmarshal(record.Value, rule)
+}
+
+// Rules is an in-memory index of the key prefix grants
+// stored in the cluster
+type Rules struct {
+	mu    sync.RWMutex
+	rules map[string]map[string]*api.ACLRule
+}
+
+func NewRules() *Rules {
+	return &Rules{rules: make(map[string]map[string]*api.ACLRule)}
+}
+
+// Apply updates the index from a record written under KeyPrefix
+func (r *Rules) Apply(record *api.Record) error {
+	if record.Deleted {
+		parts := strings.SplitN(strings.TrimPrefix(record.Id, KeyPrefix), "\x00", 2)
+		if len(parts) == 2 {
+			r.Delete(parts[0], parts[1])
+		}
+		return nil
+	}
+	rule, err := Decode(record)
+	if err != nil {
+		return err
+	}
+	r.Put(rule)
+	return nil
+}
+
+// Put adds or replaces the rule for its subject and prefix
+func (r *Rules) Put(rule *api.ACLRule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prefixes, ok := r.rules[rule.Subject]
+	if !ok {
+		prefixes = make(map[string]*api.ACLRule)
+		r.rules[rule.Subject] = prefixes
+	}
+	prefixes[rule.Prefix] = rule
+}
+
+// Delete removes the rule for a subject and prefix
+func (r *Rules) Delete(subject, prefix string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.rules[subject], prefix)
+	if len(r.rules[subject]) == 0 {
+		delete(r.rules, subject)
+	}
+}
+
+// Reset replaces every rule with those stored in `records`
+func (r *Rules) Reset(records []*api.Record) error {
+	rules := make(map[string]map[string]*api.ACLRule)
+	for _, record := range records {
+		rule, err := Decode(record)
+		if err != nil {
+			return err
+		}
+		if rules[rule.Subject] == nil {
+			rules[rule.Subject] = make(map[string]*api.ACLRule)
+		}
+		rules[rule.Subject][rule.Prefix] = rule
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = rules
+	return nil
+}
+
+// List returns every rule sorted by subject and prefix
+func (r *Rules) List() []*api.ACLRule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var rules []*api.ACLRule
+	for _, prefixes := range r.rules {
+		for _, rule := range prefixes {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Subject != rules[j].Subject {
+			return rules[i].Subject < rules[j].Subject
+		}
+		return rules[i].Prefix < rules[j].Prefix
+	})
+	return rules
+}
+
+// Allowed reports whether a rule for the subject, or for every
+// subject, grants reading or writing the key
+func (r *Rules) Allowed(subject, key string, write bool) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, sub := range []string{subject, Wildcard} {
+		for prefix, rule := range r.rules[sub] {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if (write && rule.Write) || (!write && rule.Read) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowed(t *testing.T) {
+	r := NewRules()
+	r.Put(&api.ACLRule{Subject: "team-a", Prefix: "a/", Read: true, Write: true})
+	r.Put(&api.ACLRule{Subject: "team-b", Prefix: "a/", Read: true})
+	r.Put(&api.ACLRule{Subject: Wildcard, Prefix: "public/", Read: true})
+
+	for _, tc := range []struct {
+		subject string
+		key     string
+		write   bool
+		allowed bool
+	}{
+		{"team-a", "a/key", true, true},
+		{"team-a", "b/key", false, false},
+		{"team-b", "a/key", false, true},
+		{"team-b", "a/key", true, false},
+		{"team-c", "public/key", false, true},
+		{"team-c", "public/key", true, false},
+		{"team-c", "a/key", false, false},
+	} {
+		require.Equal(t, tc.allowed, r.Allowed(tc.subject, tc.key, tc.write), "%+v", tc)
+	}
+}
+
+func TestApplyRecords(t *testing.T) {
+	r := NewRules()
+	rule := &api.ACLRule{Subject: "team-a", Prefix: "a/", Read: true}
+	record, err := Encode(rule)
+	require.NoError(t, err)
+	require.Equal(t, Key("team-a", "a/"), record.Id)
+
+	require.NoError(t, r.Apply(record))
+	require.Len(t, r.List(), 1)
+	require.True(t, r.Allowed("team-a", "a/key", false))
+
+	require.NoError(t, r.Apply(&api.Record{Id: record.Id, Deleted: true}))
+	require.Empty(t, r.List())
+
+	require.NoError(t, r.Reset([]*api.Record{record}))
+	require.Equal(t, "a/", r.List()[0].Prefix)
+}
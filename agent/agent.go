@@ -6,14 +6,24 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
+	"github.com/michael-diggin/yass/audit"
+	"github.com/michael-diggin/yass/auth"
+	"github.com/michael-diggin/yass/config"
 	"github.com/michael-diggin/yass/discovery"
 	"github.com/michael-diggin/yass/distributed"
+	"github.com/michael-diggin/yass/keyring"
+	"github.com/michael-diggin/yass/log"
+	"github.com/michael-diggin/yass/metrics"
 	"github.com/michael-diggin/yass/server"
+	"github.com/michael-diggin/yass/tracing"
 	"github.com/soheilhy/cmux"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -25,6 +35,14 @@ type Agent struct {
 	db         *distributed.YassDB
 	server     *grpc.Server
 	membership *discovery.Membership
+	metrics    *metrics.Metrics
+	tracing    *tracing.Tracing
+	authorizer *auth.Authorizer
+	auditor    *audit.Logger
+	reloaders  []*config.CertReloader
+	httpServer *http.Server
+	gateway    *http.Server
+	resp       *server.RESPServer
 	mux        cmux.CMux
 
 	shutdown     bool
@@ -41,6 +59,71 @@ type Config struct {
 	NodeName        string
 	StartJoinAddrs  []string
 	Bootstrap       bool
+	// ServerTLSFiles and PeerTLSFiles are used in place of
+	// ServerTLSConfig and PeerTLSConfig when set, their certificates
+	// are reloaded every CertReloadInterval if the files change
+	ServerTLSFiles     *config.TLSConfig
+	PeerTLSFiles       *config.TLSConfig
+	CertReloadInterval time.Duration
+	// RaftLogStore selects the Raft log implementation,
+	// see distributed.BoltLogStore and distributed.SegmentedLogStore
+	RaftLogStore string
+	// EncryptionKeyFile holds the keys records are encrypted with at
+	// rest, see keyring.Load. Records are stored in plaintext when it
+	// is empty, and the bolt Raft log store is never encrypted
+	EncryptionKeyFile string
+	// LogRetention drops the oldest of the store's log segments and
+	// the keys last written to them, see distributed.YassDB.Retain.
+	// Keys are kept until they're deleted when it's zero
+	LogRetention log.Retention
+	// LogCompression compresses the records of new log segments,
+	// see distributed.Config.Compression
+	LogCompression log.Codec
+	// GossipKeyringFile holds the keys serf gossip is encrypted with,
+	// see discovery.Config.KeyringFile. Gossip is unencrypted when empty
+	GossipKeyringFile string
+	// ACLPolicyFile maps client certificate subjects to the roles
+	// and methods they may call, it is reloaded whenever it changes
+	ACLPolicyFile string
+	// KeyACLs restricts the keys each certificate subject may
+	// read and write to those granted by the cluster's ACL rules
+	KeyACLs bool
+	// AuditLogFile is where every mutating call is recorded as
+	// JSON lines, rotated at AuditMaxBytes. Calls aren't audited
+	// when it is empty
+	AuditLogFile  string
+	AuditMaxBytes int64
+	// RateLimits throttles each client's calls, see server.RateLimits
+	RateLimits *server.RateLimits
+	// MaxInFlightApplies bounds the writes waiting on Raft at once
+	MaxInFlightApplies int
+	// MaxKeyBytes and MaxValueBytes bound the records clients may
+	// set, the server's defaults are used when they are zero
+	MaxKeyBytes   int
+	MaxValueBytes int
+	// GRPCReflection registers gRPC server reflection
+	GRPCReflection bool
+	// HTTPGateway serves the storage service as HTTP/JSON, see
+	// server.NewHTTPHandler. It listens on HTTPAddr when set, or
+	// on the RPC port for plaintext HTTP/1 when TLS isn't enabled
+	HTTPGateway bool
+	HTTPAddr    string
+	// RESP serves keys over the Redis protocol, see server.RESPServer.
+	// It listens on RESPAddr when set, or on the RPC port when TLS
+	// isn't enabled
+	RESP     bool
+	RESPAddr string
+	// RestoreFile is a backup archive, see the backup package, to seed
+	// a brand-new cluster with. It's restored by the bootstrapping node
+	// and skipped once the cluster has been written to
+	RestoreFile string
+	// MetricsAddr is the address the Prometheus /metrics endpoint
+	// listens on, it is disabled when empty. Only one agent in a
+	// process can enable it at a time
+	MetricsAddr string
+	// TraceExporter receives the spans recorded by this node,
+	// tracing is disabled when it is nil
+	TraceExporter sdktrace.SpanExporter
 }
 
 func (c Config) RPCAddr() (string, error) {
@@ -59,10 +142,13 @@ func New(config Config) (*Agent, error) {
 
 	setup := []func() error{
 		a.setupLogger,
+		a.setupTLS,
+		a.setupTracing,
 		a.setupMux,
 		a.setupDB,
-		a.setupServer,
 		a.setupMembership,
+		a.setupMetrics,
+		a.setupServer,
 	}
 
 	for _, fn := range setup {
@@ -83,6 +169,38 @@ func (a *Agent) setupLogger() error {
 	return nil
 }
 
+func (a *Agent) setupTLS() error {
+	for _, tc := range []struct {
+		files  *config.TLSConfig
+		config **tls.Config
+	}{
+		{a.Config.ServerTLSFiles, &a.Config.ServerTLSConfig},
+		{a.Config.PeerTLSFiles, &a.Config.PeerTLSConfig},
+	} {
+		if tc.files == nil {
+			continue
+		}
+		r, err := config.NewCertReloader(*tc.files, a.Config.CertReloadInterval)
+		if err != nil {
+			return err
+		}
+		a.reloaders = append(a.reloaders, r)
+		*tc.config = r.TLSConfig()
+	}
+	return nil
+}
+
+func (a *Agent) setupTracing() (err error) {
+	if a.Config.TraceExporter == nil {
+		return nil
+	}
+	a.tracing, err = tracing.New(tracing.Config{
+		Exporter: a.Config.TraceExporter,
+		NodeName: a.Config.NodeName,
+	})
+	return err
+}
+
 func (a *Agent) setupMux() error {
 	rpcAddr := fmt.Sprintf(":%d", a.Config.RPCPort)
 	ln, err := net.Listen("tcp", rpcAddr)
@@ -108,6 +226,15 @@ func (a *Agent) setupDB() (err error) {
 	)
 	conf.Raft.LocalID = raft.ServerID(a.Config.NodeName)
 	conf.Raft.Bootstrap = a.Config.Bootstrap
+	conf.Raft.LogStore = a.Config.RaftLogStore
+	conf.Retention = a.Config.LogRetention
+	conf.Compression = a.Config.LogCompression
+	if a.Config.EncryptionKeyFile != "" {
+		conf.Cipher, err = keyring.Load(a.Config.EncryptionKeyFile)
+		if err != nil {
+			return err
+		}
+	}
 
 	a.db, err = distributed.NewYassDB(a.Config.DataDir, conf)
 	if err != nil {
@@ -116,12 +243,98 @@ func (a *Agent) setupDB() (err error) {
 	if a.Config.Bootstrap {
 		err = a.db.WaitForLeader(3 * time.Second)
 	}
+	if err != nil || a.Config.RestoreFile == "" {
+		return err
+	}
+	return a.restore()
+}
+
+// restore seeds the cluster being bootstrapped with a backup
+// archive, unless it has been written to since an earlier start
+func (a *Agent) restore() error {
+	if !a.Config.Bootstrap {
+		return fmt.Errorf("a backup can only be restored by the bootstrapping node")
+	}
+	f, err := os.Open(a.Config.RestoreFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = a.db.RestoreBackup(f)
+	if err == distributed.ErrNotEmpty {
+		zap.L().Info("skipping restore, the cluster has already been written to",
+			zap.String("file", a.Config.RestoreFile))
+		return nil
+	}
 	return err
 }
 
+func (a *Agent) setupMetrics() (err error) {
+	if a.Config.MetricsAddr == "" {
+		return nil
+	}
+	a.metrics, err = metrics.New(metrics.Config{
+		DB:         a.db,
+		Membership: a.membership,
+	})
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", a.Config.MetricsAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", a.metrics.Handler())
+	a.httpServer = &http.Server{Handler: mux}
+	go func() {
+		if err := a.httpServer.Serve(ln); err != http.ErrServerClosed {
+			a.Shutdown()
+		}
+	}()
+	return nil
+}
+
 func (a *Agent) setupServer() (err error) {
 	serverConfig := &server.Config{
-		DB: a.db,
+		DB:         a.db,
+		Cluster:    a.db,
+		Membership: a.membership,
+		Namespaces: a.db,
+		Bulk:       a.db,
+		Readiness:  a.db,
+		Reflection: a.Config.GRPCReflection,
+
+		RateLimits:         a.Config.RateLimits,
+		MaxInFlightApplies: a.Config.MaxInFlightApplies,
+		MaxKeyBytes:        a.Config.MaxKeyBytes,
+		MaxValueBytes:      a.Config.MaxValueBytes,
+	}
+	if a.Config.GossipKeyringFile != "" {
+		serverConfig.GossipKeys = a.membership
+	}
+	if a.Config.KeyACLs {
+		serverConfig.ACLs = a.db
+	}
+	if a.Config.AuditLogFile != "" {
+		a.auditor, err = audit.New(audit.Config{
+			File:     a.Config.AuditLogFile,
+			MaxBytes: a.Config.AuditMaxBytes,
+		})
+		if err != nil {
+			return err
+		}
+		serverConfig.Auditor = a.auditor
+	}
+	if a.Config.ACLPolicyFile != "" {
+		a.authorizer, err = auth.New(auth.Config{PolicyFile: a.Config.ACLPolicyFile})
+		if err != nil {
+			return err
+		}
+		serverConfig.Authorizer = a.authorizer
+	}
+	if a.metrics != nil {
+		serverConfig.GRPCMetrics = a.metrics.GRPC
 	}
 	var opts []grpc.ServerOption
 	if a.Config.ServerTLSConfig != nil {
@@ -132,6 +345,12 @@ func (a *Agent) setupServer() (err error) {
 	if err != nil {
 		return err
 	}
+	if err := a.setupGateway(serverConfig); err != nil {
+		return err
+	}
+	if err := a.setupRESP(serverConfig); err != nil {
+		return err
+	}
 	grpcLn := a.mux.Match(cmux.Any())
 	go func() {
 		if err := a.server.Serve(grpcLn); err != nil {
@@ -141,6 +360,74 @@ func (a *Agent) setupServer() (err error) {
 	return nil
 }
 
+// setupGateway must run before the gRPC listener is matched,
+// since that takes every connection left over
+func (a *Agent) setupGateway(serverConfig *server.Config) error {
+	if !a.Config.HTTPGateway {
+		return nil
+	}
+	var ln net.Listener
+	switch {
+	case a.Config.HTTPAddr != "":
+		var err error
+		ln, err = net.Listen("tcp", a.Config.HTTPAddr)
+		if err != nil {
+			return err
+		}
+		if a.Config.ServerTLSConfig != nil {
+			ln = tls.NewListener(ln, a.Config.ServerTLSConfig)
+		}
+	case a.Config.ServerTLSConfig == nil:
+		ln = a.mux.Match(cmux.HTTP1Fast())
+	default:
+		return fmt.Errorf("the HTTP gateway needs an HTTPAddr when TLS is enabled")
+	}
+	a.gateway = &http.Server{Handler: server.NewHTTPHandler(serverConfig)}
+	go func() {
+		if err := a.gateway.Serve(ln); err != http.ErrServerClosed {
+			a.Shutdown()
+		}
+	}()
+	return nil
+}
+
+// setupRESP, like setupGateway, must run before the gRPC listener is matched
+func (a *Agent) setupRESP(serverConfig *server.Config) error {
+	if !a.Config.RESP {
+		return nil
+	}
+	var ln net.Listener
+	switch {
+	case a.Config.RESPAddr != "":
+		var err error
+		ln, err = net.Listen("tcp", a.Config.RESPAddr)
+		if err != nil {
+			return err
+		}
+		if a.Config.ServerTLSConfig != nil {
+			ln = tls.NewListener(ln, a.Config.ServerTLSConfig)
+		}
+	case a.Config.ServerTLSConfig == nil:
+		// clients send commands as arrays, which start with '*'
+		ln = a.mux.Match(func(reader io.Reader) bool {
+			b := make([]byte, 1)
+			if _, err := reader.Read(b); err != nil {
+				return false
+			}
+			return b[0] == '*'
+		})
+	default:
+		return fmt.Errorf("the RESP listener needs a RESPAddr when TLS is enabled")
+	}
+	a.resp = server.NewRESPServer(serverConfig)
+	go func() {
+		if err := a.resp.Serve(ln); err != server.ErrRESPServerClosed {
+			a.Shutdown()
+		}
+	}()
+	return nil
+}
+
 func (a *Agent) setupMembership() (err error) {
 	rpcAddr, err := a.RPCAddr()
 	if err != nil {
@@ -152,6 +439,7 @@ func (a *Agent) setupMembership() (err error) {
 			BindAddr:       a.Config.BindAddr,
 			Tags:           map[string]string{"rpc_addr": rpcAddr},
 			StartJoinAddrs: a.Config.StartJoinAddrs,
+			KeyringFile:    a.Config.GossipKeyringFile,
 		},
 	)
 	return err
@@ -181,7 +469,57 @@ func (a *Agent) Shutdown() error {
 			a.server.GracefulStop()
 			return nil
 		},
+		func() error {
+			if a.httpServer == nil {
+				return nil
+			}
+			return a.httpServer.Close()
+		},
+		func() error {
+			if a.gateway == nil {
+				return nil
+			}
+			return a.gateway.Close()
+		},
+		func() error {
+			if a.resp == nil {
+				return nil
+			}
+			return a.resp.Close()
+		},
+		func() error {
+			if a.auditor == nil {
+				return nil
+			}
+			return a.auditor.Close()
+		},
+		func() error {
+			if a.authorizer == nil {
+				return nil
+			}
+			return a.authorizer.Close()
+		},
 		a.db.Close,
+		func() error {
+			if a.metrics == nil {
+				return nil
+			}
+			return a.metrics.Close()
+		},
+		func() error {
+			for _, r := range a.reloaders {
+				if err := r.Close(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		func() error {
+			if a.tracing == nil {
+				return nil
+			}
+			return a.tracing.Shutdown()
+		},
 	}
 
 	for _, fn := range shutdown {
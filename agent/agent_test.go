@@ -1,16 +1,22 @@
 package agent
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/audit"
 	"github.com/michael-diggin/yass/config"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
@@ -44,11 +50,19 @@ func TestAgent(t *testing.T) {
 		datadir, err := ioutil.TempDir("", "agent-test-plog")
 		require.NoError(t, err)
 
+		var metricsAddr, auditLogFile, httpAddr, respAddr string
+		if i == 0 {
+			metricsAddr = fmt.Sprintf("127.0.0.1:%d", getFreePort())
+			auditLogFile = filepath.Join(datadir, "audit.log")
+			httpAddr = fmt.Sprintf("127.0.0.1:%d", getFreePort())
+			respAddr = fmt.Sprintf("127.0.0.1:%d", getFreePort())
+		}
+
 		var startJoinAddrs []string
 		if i > 0 {
 			startJoinAddrs = append(startJoinAddrs, agents[0].Config.BindAddr)
 		}
-		agent, err := New(Config{
+		c := Config{
 			NodeName:        fmt.Sprintf("%d", i),
 			StartJoinAddrs:  startJoinAddrs,
 			BindAddr:        bindAddr,
@@ -57,7 +71,32 @@ func TestAgent(t *testing.T) {
 			ServerTLSConfig: serverTLSConfig,
 			PeerTLSConfig:   peerTLSConfig,
 			Bootstrap:       i == 0,
-		})
+			ACLPolicyFile:   config.ACLPolicyFile,
+			MetricsAddr:     metricsAddr,
+			AuditLogFile:    auditLogFile,
+			HTTPGateway:     httpAddr != "",
+			HTTPAddr:        httpAddr,
+			RESP:            respAddr != "",
+			RESPAddr:        respAddr,
+		}
+		// one node reloads its certificates from their files
+		if i == 1 {
+			c.ServerTLSConfig, c.PeerTLSConfig = nil, nil
+			c.ServerTLSFiles = &config.TLSConfig{
+				CertFile:      config.ServerCertFile,
+				KeyFile:       config.ServerKeyFile,
+				CAFile:        config.CAFile,
+				Server:        true,
+				ServerAddress: "127.0.0.1",
+			}
+			c.PeerTLSFiles = &config.TLSConfig{
+				CertFile:      config.ClientCertFile,
+				KeyFile:       config.ClientKeyFile,
+				CAFile:        config.CAFile,
+				ServerAddress: "127.0.0.1",
+			}
+		}
+		agent, err := New(c)
 		require.NoError(t, err)
 		agents = append(agents, agent)
 	}
@@ -97,6 +136,60 @@ func TestAgent(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, []byte("hello world"), getResp.Record.Value)
 
+	adminTLSConfig, err := config.SetUpTLSConfig(config.TLSConfig{
+		CertFile:      config.AdminCertFile,
+		KeyFile:       config.AdminKeyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	admin := adminClient(t, agents[0], adminTLSConfig)
+	members, err := admin.ListMembers(context.Background(), &api.ListMembersRequest{})
+	require.NoError(t, err)
+	require.Len(t, members.Members, 3)
+	stats, err := admin.RaftStats(context.Background(), &api.RaftStatsRequest{})
+	require.NoError(t, err)
+	require.Len(t, stats.Servers, 3)
+	require.Equal(t, "Leader", stats.Stats["state"])
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", agents[0].Config.MetricsAddr))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "yass_raft_leader 1")
+	require.Contains(t, string(b), "yass_kv_keys 1")
+	require.Contains(t, string(b), `grpc_server_handled_total{grpc_code="OK",grpc_method="Set",grpc_service="api.Storage",grpc_type="unary"} 1`)
+
+	// the gateway serves the key over HTTPS to the same client
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: peerTLSConfig}}
+	resp, err = httpClient.Get(fmt.Sprintf("https://%s/v1/keys/test-key", agents[0].Config.HTTPAddr))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var record struct{ Value []byte }
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&record))
+	require.Equal(t, []byte("hello world"), record.Value)
+
+	// and over the Redis protocol
+	respConn, err := tls.Dial("tcp", agents[0].Config.RESPAddr, peerTLSConfig)
+	require.NoError(t, err)
+	defer respConn.Close()
+	_, err = respConn.Write([]byte("*2\r\n$3\r\nGET\r\n$8\r\ntest-key\r\n"))
+	require.NoError(t, err)
+	reply := make([]byte, len("$11\r\nhello world\r\n"))
+	_, err = io.ReadFull(respConn, reply)
+	require.NoError(t, err)
+	require.Equal(t, "$11\r\nhello world\r\n", string(reply))
+
+	// the Set is audited along with the Raft index it was applied at
+	b, err = ioutil.ReadFile(agents[0].Config.AuditLogFile)
+	require.NoError(t, err)
+	var entry audit.Entry
+	require.NoError(t, json.Unmarshal(bytes.SplitN(b, []byte("\n"), 2)[0], &entry))
+	require.Equal(t, "client", entry.Subject)
+	require.Equal(t, "test-key", entry.Key)
+	require.NotZero(t, entry.RaftIndex)
 }
 
 func client(t *testing.T, agent *Agent, tlsConfig *tls.Config) api.StorageClient {
@@ -108,6 +201,15 @@ func client(t *testing.T, agent *Agent, tlsConfig *tls.Config) api.StorageClient
 	return api.NewStorageClient(conn)
 }
 
+func adminClient(t *testing.T, agent *Agent, tlsConfig *tls.Config) api.AdminClient {
+	tlsCreds := credentials.NewTLS(tlsConfig)
+	rpcAddr, err := agent.RPCAddr()
+	require.NoError(t, err)
+	conn, err := grpc.Dial(rpcAddr, grpc.WithTransportCredentials(tlsCreds))
+	require.NoError(t, err)
+	return api.NewAdminClient(conn)
+}
+
 func getFreePort() int {
 	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
 	if err != nil {
@@ -36,3 +36,48 @@ func (e ErrNotFound) GRPCStatus() *status.Status {
 func (e ErrNotFound) Error() string {
 	return e.GRPCStatus().Err().Error()
 }
+
+type ErrNamespaceNotFound struct {
+	Name string
+}
+
+// GRPCStatus implements the GRPC status interface
+func (e ErrNamespaceNotFound) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, fmt.Sprintf("no namespace found: %s", e.Name))
+}
+
+// Error implements the error interface
+func (e ErrNamespaceNotFound) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+type ErrQuotaExceeded struct {
+	Namespace string
+}
+
+// GRPCStatus implements the GRPC status interface
+func (e ErrQuotaExceeded) GRPCStatus() *status.Status {
+	return status.New(codes.ResourceExhausted, fmt.Sprintf("quota exceeded for namespace: %s", e.Namespace))
+}
+
+// Error implements the error interface
+func (e ErrQuotaExceeded) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// ErrConflict is returned when a conditional write finds
+// the key has changed since the offset
+type ErrConflict struct {
+	Id     string
+	Offset uint64
+}
+
+// GRPCStatus implements the GRPC status interface
+func (e ErrConflict) GRPCStatus() *status.Status {
+	return status.New(codes.Aborted, fmt.Sprintf("record for Id %s changed since offset %d", e.Id, e.Offset))
+}
+
+// Error implements the error interface
+func (e ErrConflict) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
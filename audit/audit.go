@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entry records one mutating operation
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Subject is the common name of the caller's certificate
+	Subject   string `json:"subject"`
+	Method    string `json:"method"`
+	Namespace string `json:"namespace,omitempty"`
+	Key       string `json:"key,omitempty"`
+	// Target names what a non key operation acted on,
+	// e.g. a server, ACL rule or namespace
+	Target    string `json:"target,omitempty"`
+	Code      string `json:"code"`
+	Error     string `json:"error,omitempty"`
+	RaftIndex uint64 `json:"raft_index,omitempty"`
+}
+
+type Config struct {
+	File string
+	// MaxBytes is the size a file is rotated at
+	MaxBytes int64
+	// MaxBackups is the number of rotated files kept, all are
+	// kept when it is zero
+	MaxBackups int
+}
+
+// Logger appends entries to a file as JSON lines. When the file
+// reaches MaxBytes it is renamed with a timestamp suffix and a new
+// file is started, existing files are never written to again
+type Logger struct {
+	Config
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func New(config Config) (*Logger, error) {
+	if config.MaxBytes == 0 {
+		config.MaxBytes = 64 << 20
+	}
+	l := &Logger{Config: config}
+	return l, l.open()
+}
+
+func (l *Logger) open() error {
+	if err := os.MkdirAll(filepath.Dir(l.File), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = fi.Size()
+	return nil
+}
+
+// Write appends an entry, rotating the file first if
+// the entry would take it past MaxBytes
+func (l *Logger) Write(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size > 0 && l.size+int64(len(b)) > l.MaxBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(b)
+	l.size += int64(n)
+	return err
+}
+
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	rotated := fmt.Sprintf("%s.%s", l.File, time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := os.Rename(l.File, rotated); err != nil {
+		return err
+	}
+	if err := l.removeBackups(); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// removeBackups removes the oldest rotated files over MaxBackups
+func (l *Logger) removeBackups() error {
+	if l.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(l.File + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > l.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Close syncs and closes the current file
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	return l.file.Close()
+}
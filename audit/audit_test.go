@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAndRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "audit.log")
+	l, err := New(Config{File: file, MaxBytes: 300, MaxBackups: 2})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, l.Write(Entry{
+			Subject:   "client",
+			Method:    "/api.Storage/Set",
+			Key:       "key",
+			Code:      "OK",
+			RaftIndex: uint64(i + 1),
+		}))
+	}
+	require.NoError(t, l.Close())
+
+	backups, err := filepath.Glob(file + ".*")
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+
+	var last Entry
+	for _, f := range append(backups, file) {
+		fi, err := os.Stat(f)
+		require.NoError(t, err)
+		require.LessOrEqual(t, fi.Size(), int64(300))
+
+		r, err := os.Open(f)
+		require.NoError(t, err)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			var e Entry
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+			require.Greater(t, e.RaftIndex, last.RaftIndex)
+			last = e
+		}
+		r.Close()
+	}
+	require.Equal(t, uint64(10), last.RaftIndex)
+
+	// reopening appends to the current file
+	l, err = New(Config{File: file, MaxBytes: 300})
+	require.NoError(t, err)
+	require.NotZero(t, l.size)
+	require.NoError(t, l.Close())
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Wildcard matches any subject in the policy's subjects,
+// and any method when used in a role's methods
+const Wildcard = "*"
+
+// Policy grants roles to certificate subjects,
+// and gRPC methods to roles.
+//
+// Methods are full gRPC method names, e.g. "/api.Storage/Get".
+// A method ending in "/*" grants every method of that service
+type Policy struct {
+	Roles    map[string][]string `json:"roles"`
+	Subjects map[string][]string `json:"subjects"`
+}
+
+type Config struct {
+	PolicyFile string
+	// ReloadInterval is how often the policy file is checked
+	// for changes, it is never reloaded when negative
+	ReloadInterval time.Duration
+}
+
+// Authorizer decides which methods a certificate subject may call,
+// reloading its policy whenever the policy file changes
+type Authorizer struct {
+	Config
+	logger *zap.Logger
+
+	mu      sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New loads the policy file and watches it for changes
+func New(config Config) (*Authorizer, error) {
+	if config.ReloadInterval == 0 {
+		config.ReloadInterval = 5 * time.Second
+	}
+	a := &Authorizer{
+		Config:   config,
+		logger:   zap.L().Named("auth"),
+		shutdown: make(chan struct{}),
+	}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	if a.ReloadInterval > 0 {
+		a.wg.Add(1)
+		go a.watch()
+	}
+	return a, nil
+}
+
+// Reload reads the policy file, the current policy is kept
+// if the file can't be read or parsed
+func (a *Authorizer) Reload() error {
+	fi, err := os.Stat(a.PolicyFile)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(a.PolicyFile)
+	if err != nil {
+		return err
+	}
+	policy := &Policy{}
+	if err := json.Unmarshal(b, policy); err != nil {
+		return fmt.Errorf("failed to parse policy %q: %w", a.PolicyFile, err)
+	}
+	for subject, roles := range policy.Subjects {
+		for _, role := range roles {
+			if _, ok := policy.Roles[role]; !ok {
+				return fmt.Errorf("subject %q has undefined role %q", subject, role)
+			}
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policy = policy
+	a.modTime = fi.ModTime()
+	return nil
+}
+
+// Authorize returns a PermissionDenied error unless one of
+// the subject's roles grants it the method
+func (a *Authorizer) Authorize(subject, method string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if subject != "" {
+		for _, sub := range []string{subject, Wildcard} {
+			for _, role := range a.policy.Subjects[sub] {
+				for _, allowed := range a.policy.Roles[role] {
+					if matches(allowed, method) {
+						return nil
+					}
+				}
+			}
+		}
+	}
+	return status.Errorf(codes.PermissionDenied, "%q is not permitted to call %s", subject, method)
+}
+
+// Close stops watching the policy file
+func (a *Authorizer) Close() error {
+	close(a.shutdown)
+	a.wg.Wait()
+	return nil
+}
+
+func (a *Authorizer) watch() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(a.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.shutdown:
+			return
+		case <-ticker.C:
+			fi, err := os.Stat(a.PolicyFile)
+			if err != nil {
+				a.logger.Error("failed to stat policy", zap.Error(err))
+				continue
+			}
+			a.mu.RLock()
+			changed := !fi.ModTime().Equal(a.modTime)
+			a.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := a.Reload(); err != nil {
+				a.logger.Error("failed to reload policy", zap.Error(err))
+				continue
+			}
+			a.logger.Info("reloaded policy", zap.String("file", a.PolicyFile))
+		}
+	}
+}
+
+func matches(allowed, method string) bool {
+	if allowed == Wildcard || allowed == method {
+		return true
+	}
+	if strings.HasSuffix(allowed, "/"+Wildcard) {
+		return strings.HasPrefix(method, strings.TrimSuffix(allowed, Wildcard))
+	}
+	return false
+}
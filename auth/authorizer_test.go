@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const policy = `{
+	"roles": {
+		"reader": ["/api.Storage/Get"],
+		"admin": ["/api.Admin/*"]
+	},
+	"subjects": {
+		"root": ["admin"],
+		"*": ["reader"]
+	}
+}`
+
+func TestAuthorize(t *testing.T) {
+	a, teardown := setupTest(t, policy, -1)
+	defer teardown()
+
+	for _, tc := range []struct {
+		subject string
+		method  string
+		allowed bool
+	}{
+		{"root", "/api.Admin/RaftStats", true},
+		{"root", "/api.Storage/Get", true},
+		{"root", "/api.Storage/Set", false},
+		{"client", "/api.Storage/Get", true},
+		{"client", "/api.Admin/RaftStats", false},
+		{"", "/api.Storage/Get", false},
+	} {
+		err := a.Authorize(tc.subject, tc.method)
+		if tc.allowed {
+			require.NoError(t, err, "%s %s", tc.subject, tc.method)
+		} else {
+			require.Equal(t, codes.PermissionDenied, status.Code(err), "%s %s", tc.subject, tc.method)
+		}
+	}
+}
+
+func TestPolicyReloads(t *testing.T) {
+	a, teardown := setupTest(t, policy, 10*time.Millisecond)
+	defer teardown()
+
+	require.Error(t, a.Authorize("client", "/api.Storage/Set"))
+
+	updated := `{
+		"roles": {"writer": ["/api.Storage/Set"]},
+		"subjects": {"client": ["writer"]}
+	}`
+	writePolicy(t, a.PolicyFile, updated)
+	require.Eventually(t, func() bool {
+		return a.Authorize("client", "/api.Storage/Set") == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// an invalid policy leaves the current one in place
+	writePolicy(t, a.PolicyFile, `{"subjects": {"client": ["missing"]}}`)
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, a.Authorize("client", "/api.Storage/Set"))
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = New(Config{PolicyFile: filepath.Join(dir, "missing.json")})
+	require.Error(t, err)
+
+	file := filepath.Join(dir, "policy.json")
+	writePolicy(t, file, `{"subjects": {"client": ["missing"]}}`)
+	_, err = New(Config{PolicyFile: file})
+	require.Error(t, err)
+}
+
+func setupTest(t *testing.T, policy string, interval time.Duration) (*Authorizer, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "auth-test")
+	require.NoError(t, err)
+	file := filepath.Join(dir, "policy.json")
+	writePolicy(t, file, policy)
+
+	a, err := New(Config{PolicyFile: file, ReloadInterval: interval})
+	require.NoError(t, err)
+	return a, func() {
+		if interval > 0 {
+			a.Close()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func writePolicy(t *testing.T, file, policy string) {
+	t.Helper()
+	require.NoError(t, ioutil.WriteFile(file, []byte(policy), 0644))
+}
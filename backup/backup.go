@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// An archive holds a snapshot of the cluster's FSM:
+//
+//	magic    8 bytes, "YASSBAK1"
+//	length   8 bytes, big endian length of the metadata
+//	metadata Meta as JSON
+//	snapshot Meta.Size bytes, the FSM snapshot: a sequence of records,
+//	         each a big endian uint64 length then the record as
+//	         marshalled by log.Marshal, so they're encrypted when the
+//	         cluster encrypts its log
+//	checksum 32 bytes, SHA-256 of everything before it
+var magic = []byte("YASSBAK1")
+
+const lenWidth = 8
+
+var ErrChecksum = errors.New("backup: checksum mismatch")
+
+// Meta describes the snapshot held in an archive
+type Meta struct {
+	Version   int       `json:"version"`
+	Index     uint64    `json:"index"`
+	Term      uint64    `json:"term"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Write writes an archive of the Size bytes of snapshot read from r
+func Write(w io.Writer, meta Meta, r io.Reader) error {
+	meta.Version = 1
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	w = io.MultiWriter(w, h)
+
+	header := make([]byte, len(magic)+lenWidth)
+	copy(header, magic)
+	binary.BigEndian.PutUint64(header[len(magic):], uint64(len(b)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+	if n != meta.Size {
+		return fmt.Errorf("backup: snapshot is %d bytes, expected %d", n, meta.Size)
+	}
+	_, err = w.Write(h.Sum(nil))
+	return err
+}
+
+// Read verifies the archive's checksum and returns its metadata
+// and a reader of its snapshot
+func Read(r io.ReadSeeker) (Meta, io.Reader, error) {
+	var meta Meta
+	if err := verify(r); err != nil {
+		return meta, nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return meta, nil, err
+	}
+	header := make([]byte, len(magic)+lenWidth)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return meta, nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint64(header[len(magic):]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return meta, nil, err
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return meta, nil, err
+	}
+	if meta.Version != 1 {
+		return meta, nil, fmt.Errorf("backup: unsupported version %d", meta.Version)
+	}
+	return meta, io.LimitReader(r, meta.Size), nil
+}
+
+// verify checks the magic and that the trailing checksum
+// matches the rest of the archive
+func verify(r io.ReadSeeker) error {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	b := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, b); err != nil || !bytes.Equal(b, magic) {
+		return errors.New("backup: not a yass archive")
+	}
+	if size < int64(len(magic)+lenWidth+sha256.Size) {
+		return errors.New("backup: archive is truncated")
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, r, size-sha256.Size); err != nil {
+		return err
+	}
+	sum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, sum); err != nil {
+		return err
+	}
+	if !bytes.Equal(sum, h.Sum(nil)) {
+		return ErrChecksum
+	}
+	return nil
+}
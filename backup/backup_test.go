@@ -0,0 +1,38 @@
+package backup
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	snapshot := []byte("snapshot data")
+	meta := Meta{Index: 10, Term: 2, Size: int64(len(snapshot)), CreatedAt: time.Unix(100, 0).UTC()}
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, meta, bytes.NewReader(snapshot)))
+
+	got, r, err := Read(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	meta.Version = 1
+	require.Equal(t, meta, got)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, snapshot, b)
+
+	// any change to the archive is caught by its checksum
+	corrupt := append([]byte(nil), buf.Bytes()...)
+	corrupt[len(corrupt)-40] ^= 1
+	_, _, err = Read(bytes.NewReader(corrupt))
+	require.Equal(t, ErrChecksum, err)
+
+	_, _, err = Read(bytes.NewReader([]byte("not an archive at all, not at all")))
+	require.Error(t, err)
+
+	meta.Size++
+	require.Error(t, Write(&bytes.Buffer{}, meta, bytes.NewReader(snapshot)))
+}
@@ -0,0 +1,178 @@
+package bulk
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/michael-diggin/yass/api"
+)
+
+// Format is how records are laid out in a file
+type Format string
+
+const (
+	// JSONL writes a JSON object per line:
+	//	{"namespace":"ns","id":"key","value":"v","expires_at":1700000000000000000}
+	// namespace and expires_at are left out when empty
+	JSONL Format = "jsonl"
+	// CSV writes a header then a row per record:
+	//	namespace,id,value,expires_at
+	CSV Format = "csv"
+)
+
+var csvHeader = []string{"namespace", "id", "value", "expires_at"}
+
+type Options struct {
+	Format Format
+	// Base64 encodes values as base64, otherwise they're written
+	// as they are and must be valid UTF-8
+	Base64 bool
+	// Append leaves out the CSV header, for adding to a file
+	// that already has one
+	Append bool
+}
+
+type line struct {
+	Namespace string `json:"namespace,omitempty"`
+	ID        string `json:"id"`
+	Value     string `json:"value"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+// Writer writes records to a file in the format of its Options
+type Writer struct {
+	opts Options
+	buf  *bufio.Writer
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+func NewWriter(w io.Writer, opts Options) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	wr := &Writer{opts: opts, buf: bw}
+	switch opts.Format {
+	case JSONL:
+		wr.json = json.NewEncoder(bw)
+		wr.json.SetEscapeHTML(false)
+	case CSV:
+		wr.csv = csv.NewWriter(bw)
+		if !opts.Append {
+			if err := wr.csv.Write(csvHeader); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", opts.Format)
+	}
+	return wr, nil
+}
+
+func (w *Writer) Write(record *api.Record) error {
+	value := string(record.Value)
+	if w.opts.Base64 {
+		value = base64.StdEncoding.EncodeToString(record.Value)
+	} else if !utf8.Valid(record.Value) {
+		return fmt.Errorf("the value of %q isn't valid UTF-8, write values as base64", record.Id)
+	}
+	if w.json != nil {
+		return w.json.Encode(line{
+			Namespace: record.Namespace,
+			ID:        record.Id,
+			Value:     value,
+			ExpiresAt: record.ExpiresAt,
+		})
+	}
+	expiresAt := ""
+	if record.ExpiresAt != 0 {
+		expiresAt = strconv.FormatInt(record.ExpiresAt, 10)
+	}
+	return w.csv.Write([]string{record.Namespace, record.Id, value, expiresAt})
+}
+
+// Flush writes any buffered records to the underlying writer
+func (w *Writer) Flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return w.buf.Flush()
+}
+
+// Reader reads the records from a file in the format of its Options
+type Reader struct {
+	opts Options
+	csv  *csv.Reader
+	json *json.Decoder
+	// n is the number of records read
+	n int
+}
+
+func NewReader(r io.Reader, opts Options) (*Reader, error) {
+	rd := &Reader{opts: opts}
+	switch opts.Format {
+	case JSONL:
+		rd.json = json.NewDecoder(bufio.NewReader(r))
+		rd.json.DisallowUnknownFields()
+	case CSV:
+		rd.csv = csv.NewReader(bufio.NewReader(r))
+		rd.csv.FieldsPerRecord = len(csvHeader)
+		header, err := rd.csv.Read()
+		if err == io.EOF {
+			return rd, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, name := range csvHeader {
+			if header[i] != name {
+				return nil, fmt.Errorf("the CSV header must be %v", csvHeader)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", opts.Format)
+	}
+	return rd, nil
+}
+
+// Read returns the next record, or io.EOF when there are no more
+func (r *Reader) Read() (*api.Record, error) {
+	var l line
+	if r.json != nil {
+		if err := r.json.Decode(&l); err != nil {
+			if err == io.EOF {
+				return nil, err
+			}
+			return nil, fmt.Errorf("record %d: %v", r.n+1, err)
+		}
+	} else {
+		row, err := r.csv.Read()
+		if err != nil {
+			return nil, err
+		}
+		l = line{Namespace: row[0], ID: row[1], Value: row[2]}
+		if row[3] != "" {
+			if l.ExpiresAt, err = strconv.ParseInt(row[3], 10, 64); err != nil {
+				return nil, fmt.Errorf("record %d: invalid expires_at %q", r.n+1, row[3])
+			}
+		}
+	}
+	r.n++
+
+	record := &api.Record{Namespace: l.Namespace, Id: l.ID, Value: []byte(l.Value), ExpiresAt: l.ExpiresAt}
+	if r.opts.Base64 {
+		value, err := base64.StdEncoding.DecodeString(l.Value)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: value isn't base64: %v", r.n, err)
+		}
+		record.Value = value
+	}
+	return record, nil
+}
@@ -0,0 +1,72 @@
+package bulk
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	records := []*api.Record{
+		{Id: "a", Value: []byte(`{"json": "value"}`)},
+		{Namespace: "ns", Id: "b,c", Value: []byte("multi\nline"), ExpiresAt: 1700000000000000000},
+		{Id: "empty", Value: []byte{}},
+	}
+	for _, opts := range []Options{
+		{Format: JSONL},
+		{Format: JSONL, Base64: true},
+		{Format: CSV},
+		{Format: CSV, Base64: true},
+	} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, opts)
+		require.NoError(t, err)
+		for _, record := range records {
+			require.NoError(t, w.Write(record))
+		}
+		require.NoError(t, w.Flush())
+
+		r, err := NewReader(&buf, opts)
+		require.NoError(t, err)
+		for _, want := range records {
+			got, err := r.Read()
+			require.NoError(t, err, "%+v", opts)
+			require.Equal(t, want.Namespace, got.Namespace)
+			require.Equal(t, want.Id, got.Id)
+			require.Equal(t, want.Value, got.Value)
+			require.Equal(t, want.ExpiresAt, got.ExpiresAt)
+		}
+		_, err = r.Read()
+		require.Equal(t, io.EOF, err)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Options{Format: JSONL})
+	require.NoError(t, err)
+	require.NoError(t, w.Write(&api.Record{Id: "a", Value: []byte("<v>")}))
+	require.Error(t, w.Write(&api.Record{Id: "b", Value: []byte{0xff}}))
+	require.NoError(t, w.Flush())
+	require.Equal(t, `{"id":"a","value":"<v>"}`+"\n", buf.String())
+
+	buf.Reset()
+	w, err = NewWriter(&buf, Options{Format: CSV, Base64: true})
+	require.NoError(t, err)
+	require.NoError(t, w.Write(&api.Record{Namespace: "ns", Id: "a", Value: []byte{0xff}, ExpiresAt: 5}))
+	require.NoError(t, w.Flush())
+	require.Equal(t, "namespace,id,value,expires_at\nns,a,/w==,5\n", buf.String())
+
+	_, err = NewReader(bytes.NewBufferString("id,value\n"), Options{Format: CSV})
+	require.Error(t, err)
+	_, err = NewWriter(&buf, Options{Format: "xml"})
+	require.Error(t, err)
+
+	r, err := NewReader(bytes.NewBufferString(`{"id":"a","unknown":1}`), Options{Format: JSONL})
+	require.NoError(t, err)
+	_, err = r.Read()
+	require.Error(t, err)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/michael-diggin/yass/keyring"
+	"github.com/michael-diggin/yass/log"
+)
+
+const usage = `usage: yass-logtool <command> [flags] DIR
+
+DIR is a log directory of a node that isn't running, e.g. DATADIR/plog
+or DATADIR/raft/plog.
+
+commands:
+  list DIR                      list the segments with their offsets and sizes
+  dump [-key-file FILE] DIR [OFFSET|FROM-TO]...
+                                print the records at the offsets, or every record
+  verify DIR                    check each segment's index against its store
+  rebuild-index -segment N DIR  rewrite segment N's index from its store
+  truncate DIR                  discard the corrupt tail of the last segment
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = runList(os.Args[2:])
+	case "dump":
+		err = runDump(os.Args[2:])
+	case "verify":
+		err = runVerify(os.Args[2:])
+	case "rebuild-index":
+		err = runRebuildIndex(os.Args[2:])
+	case "truncate":
+		err = runTruncate(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "yass-logtool:", err)
+		os.Exit(1)
+	}
+}
+
+// openDir parses the flags and reads the segments of the
+// directory given as the first argument
+func openDir(fs *flag.FlagSet, args []string) ([]*log.SegmentFile, error) {
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return nil, fmt.Errorf("%s takes the log directory", fs.Name())
+	}
+	segments, err := log.OpenSegments(fs.Arg(0))
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("no segments in %s", fs.Arg(0))
+	}
+	return segments, nil
+}
+
+func runList(args []string) error {
+	segments, err := openDir(flag.NewFlagSet("list", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-12s %-12s %-10s %-12s %-14s %s\n", "BASE", "NEXT", "RECORDS", "STORE BYTES", "INDEX ENTRIES", "CODEC")
+	for _, s := range segments {
+		fmt.Printf("%-12d %-12d %-10d %-12d %-14d %s\n",
+			s.BaseOffset, s.NextOffset(), len(s.Store), s.StoreBytes, len(s.Index), s.Codec)
+	}
+	return nil
+}
+
+func runDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "encryption key file, see keyring.Load, to open sealed records")
+	segments, err := openDir(fs, args)
+	if err != nil {
+		return err
+	}
+	var cipher log.Cipher
+	if *keyFile != "" {
+		if cipher, err = keyring.Load(*keyFile); err != nil {
+			return err
+		}
+	}
+
+	first, next := segments[0].BaseOffset, segments[len(segments)-1].NextOffset()
+	ranges := [][2]uint64{{first, next}}
+	if fs.NArg() > 1 {
+		ranges = nil
+		for _, arg := range fs.Args()[1:] {
+			r, err := parseRange(arg)
+			if err != nil {
+				return err
+			}
+			ranges = append(ranges, r)
+		}
+	}
+	for _, r := range ranges {
+		for off := r[0]; off < r[1]; off++ {
+			dump(segments, off, cipher)
+		}
+	}
+	return nil
+}
+
+// parseRange parses an offset, or an inclusive range FROM-TO,
+// into the offsets from the first up to the second
+func parseRange(arg string) ([2]uint64, error) {
+	from, to := arg, arg
+	if i := strings.Index(arg, "-"); i >= 0 {
+		from, to = arg[:i], arg[i+1:]
+	}
+	start, err := strconv.ParseUint(from, 10, 64)
+	if err != nil {
+		return [2]uint64{}, fmt.Errorf("invalid offset %q", arg)
+	}
+	end, err := strconv.ParseUint(to, 10, 64)
+	if err != nil || end < start {
+		return [2]uint64{}, fmt.Errorf("invalid offset %q", arg)
+	}
+	return [2]uint64{start, end + 1}, nil
+}
+
+func dump(segments []*log.SegmentFile, off uint64, cipher log.Cipher) {
+	var segment *log.SegmentFile
+	for _, s := range segments {
+		if s.BaseOffset <= off && off < s.NextOffset() {
+			segment = s
+			break
+		}
+	}
+	if segment == nil {
+		fmt.Printf("%d: not in the log\n", off)
+		return
+	}
+	record, err := segment.Read(off, cipher)
+	if err != nil {
+		fmt.Printf("%d: %v\n", off, err)
+		return
+	}
+	var flags []string
+	if record.Deleted {
+		flags = append(flags, "deleted")
+	}
+	if record.Dropped {
+		flags = append(flags, "dropped")
+	}
+	if record.HasPrev {
+		flags = append(flags, fmt.Sprintf("prev=%d", record.PrevOffset))
+	}
+	if record.ExpiresAt != 0 {
+		flags = append(flags, fmt.Sprintf("expires_at=%d", record.ExpiresAt))
+	}
+	fmt.Printf("%d: namespace=%q id=%q value=%q %s\n",
+		off, record.Namespace, record.Id, record.Value, strings.Join(flags, " "))
+}
+
+func runVerify(args []string) error {
+	segments, err := openDir(flag.NewFlagSet("verify", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	problems := 0
+	next := segments[0].BaseOffset
+	for _, s := range segments {
+		if s.BaseOffset != next {
+			fmt.Printf("segment %d: expected it to start at offset %d\n", s.BaseOffset, next)
+			problems++
+		}
+		for _, p := range s.Verify() {
+			fmt.Printf("segment %d: %s\n", s.BaseOffset, p)
+			problems++
+		}
+		next = s.NextOffset()
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problems in %d segments", problems, len(segments))
+	}
+	fmt.Printf("%d segments are consistent\n", len(segments))
+	return nil
+}
+
+func runRebuildIndex(args []string) error {
+	fs := flag.NewFlagSet("rebuild-index", flag.ExitOnError)
+	base := fs.Uint64("segment", 0, "base offset of the segment")
+	segments, err := openDir(fs, args)
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if s.BaseOffset == *base {
+			if err := s.RebuildIndex(); err != nil {
+				return err
+			}
+			fmt.Printf("segment %d: indexed %d records\n", s.BaseOffset, len(s.Index))
+			return nil
+		}
+	}
+	return fmt.Errorf("no segment with base offset %d", *base)
+}
+
+// runTruncate only truncates the last segment, a corrupt record in an
+// earlier one would leave a gap in the offsets
+func runTruncate(args []string) error {
+	segments, err := openDir(flag.NewFlagSet("truncate", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	for _, s := range segments[:len(segments)-1] {
+		if s.StoreBytes > s.ValidBytes {
+			return fmt.Errorf("segment %d isn't the last segment but has a corrupt tail", s.BaseOffset)
+		}
+	}
+	s := segments[len(segments)-1]
+	discarded := s.StoreBytes - s.ValidBytes
+	if err := s.TruncateTail(); err != nil {
+		return err
+	}
+	fmt.Printf("segment %d: discarded %d bytes, next offset %d\n", s.BaseOffset, discarded, s.NextOffset())
+	return nil
+}
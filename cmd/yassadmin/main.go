@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/backup"
+	"github.com/michael-diggin/yass/bulk"
+	"github.com/michael-diggin/yass/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const usage = `usage: yassadmin <command> [flags]
+
+commands:
+  backup -addr ADDR FILE   write a backup archive of the cluster to FILE
+  verify FILE              check a backup archive and print its metadata
+  export -addr ADDR FILE   write every record to FILE, or stdout if it's -
+  import -addr ADDR FILE   set every record in FILE, or stdin if it's -
+
+Archives are restored by starting a brand-new cluster with the
+bootstrapping agent's RestoreFile set to the archive.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "backup":
+		err = runBackup(os.Args[2:])
+	case "verify":
+		err = runVerify(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "yassadmin:", err)
+		os.Exit(1)
+	}
+}
+
+// connFlags are the flags for connecting to a node's admin service
+type connFlags struct {
+	addr, ca, cert, key, serverName *string
+}
+
+func newConnFlags(fs *flag.FlagSet) connFlags {
+	return connFlags{
+		addr:       fs.String("addr", "", "RPC address of a yass node"),
+		ca:         fs.String("ca", config.CAFile, "CA certificate file"),
+		cert:       fs.String("cert", config.AdminCertFile, "client certificate file"),
+		key:        fs.String("key", config.AdminKeyFile, "client key file"),
+		serverName: fs.String("server-name", "127.0.0.1", "name the node's certificate is verified against"),
+	}
+}
+
+func (f connFlags) dial() (*grpc.ClientConn, error) {
+	if *f.addr == "" {
+		return nil, fmt.Errorf("-addr is required")
+	}
+	tlsConfig, err := config.SetUpTLSConfig(config.TLSConfig{
+		CAFile:        *f.ca,
+		CertFile:      *f.cert,
+		KeyFile:       *f.key,
+		ServerAddress: *f.serverName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Dial(*f.addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+}
+
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	conn := newConnFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("backup takes the file to write")
+	}
+	file := fs.Arg(0)
+
+	cc, err := conn.dial()
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	stream, err := api.NewAdminClient(cc).Backup(context.Background(), &api.BackupRequest{})
+	if err != nil {
+		return err
+	}
+
+	// the archive is written next to the file and renamed
+	// into place once it's complete and verified
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tmp.Write(chunk.Data); err != nil {
+			return err
+		}
+	}
+	meta, _, err := backup.Read(tmp)
+	if err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s: index %d, term %d, %d bytes of snapshot\n", file, meta.Index, meta.Term, meta.Size)
+	return nil
+}
+
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("verify takes the file to check")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	meta, _, err := backup.Read(f)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("index %d, term %d, %d bytes of snapshot, created %s\n",
+		meta.Index, meta.Term, meta.Size, meta.CreatedAt)
+	return nil
+}
+
+func formatFlags(fs *flag.FlagSet) (*string, *bool) {
+	format := fs.String("format", string(bulk.JSONL), "file format, jsonl or csv")
+	b64 := fs.Bool("base64", false, "values are base64 encoded")
+	return format, b64
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	conn := newConnFlags(fs)
+	format, b64 := formatFlags(fs)
+	resume := fs.Bool("resume", false, "append to FILE, after the last record in it")
+	batch := fs.Int("batch", 0, "records in each batch")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("export takes the file to write")
+	}
+	file := fs.Arg(0)
+	opts := bulk.Options{Format: bulk.Format(*format), Base64: *b64}
+
+	req := &api.ExportRequest{BatchSize: int32(*batch)}
+	out := os.Stdout
+	if file != "-" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if *resume {
+			last, err := lastRecord(file, opts)
+			if err != nil {
+				return err
+			}
+			if last != nil {
+				req.AfterNamespace, req.AfterId = last.Namespace, last.Id
+				opts.Append = true
+			}
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(file, flags, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	cc, err := conn.dial()
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	stream, err := api.NewAdminClient(cc).Export(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	w, err := bulk.NewWriter(out, opts)
+	if err != nil {
+		return err
+	}
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.Flush()
+			return err
+		}
+		for _, record := range batch.Records {
+			if err := w.Write(record); err != nil {
+				w.Flush()
+				return err
+			}
+		}
+		fmt.Fprintf(os.Stderr, "exported %d/%d\n", batch.Exported, batch.Total)
+	}
+	return w.Flush()
+}
+
+// lastRecord returns the last record in a file, or nil if
+// it's empty or doesn't exist
+func lastRecord(file string, opts bulk.Options) (*api.Record, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := bulk.NewReader(f, opts)
+	if err != nil {
+		return nil, err
+	}
+	var last *api.Record
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return last, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		last = record
+	}
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	conn := newConnFlags(fs)
+	format, b64 := formatFlags(fs)
+	skip := fs.Uint64("skip", 0, "records at the start of FILE to skip, to resume an import")
+	batchSize := fs.Int("batch", 500, "records in each batch")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import takes the file to read")
+	}
+	file := fs.Arg(0)
+
+	in := os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	r, err := bulk.NewReader(in, bulk.Options{Format: bulk.Format(*format), Base64: *b64})
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < *skip; i++ {
+		if _, err := r.Read(); err != nil {
+			return err
+		}
+	}
+
+	cc, err := conn.dial()
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	stream, err := api.NewAdminClient(cc).Import(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// each batch is sent once the previous one is acknowledged,
+	// so the progress is known when an import fails
+	var imported uint64
+	done := false
+	for !done {
+		batch := &api.ImportBatch{}
+		for len(batch.Records) < *batchSize {
+			record, err := r.Read()
+			if err == io.EOF {
+				done = true
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("%v, resume with -skip %d", err, *skip+imported)
+			}
+			batch.Records = append(batch.Records, record)
+		}
+		if len(batch.Records) == 0 {
+			break
+		}
+		if err := stream.Send(batch); err != nil {
+			break
+		}
+		progress, err := stream.Recv()
+		if err != nil {
+			return fmt.Errorf("%v, resume with -skip %d", err, *skip+imported)
+		}
+		imported = progress.Imported
+		fmt.Fprintf(os.Stderr, "imported %d\n", *skip+imported)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	// a failed record is reported after the progress up to it
+	for {
+		progress, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%v, resume with -skip %d", err, *skip+imported)
+		}
+		imported = progress.Imported
+	}
+}
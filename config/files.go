@@ -11,6 +11,9 @@ var (
 	ServerKeyFile  = configFile("server-key.pem")
 	ClientCertFile = configFile("client.pem")
 	ClientKeyFile  = configFile("client-key.pem")
+	AdminCertFile  = configFile("admin-client.pem")
+	AdminKeyFile   = configFile("admin-client-key.pem")
+	ACLPolicyFile  = configFile("policy.json")
 )
 
 func configFile(filename string) string {
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CertReloader keeps the certificate, key and CA of a TLSConfig
+// in step with their files, new handshakes use the last loaded
+type CertReloader struct {
+	cfg      TLSConfig
+	interval time.Duration
+	logger   *zap.Logger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	ca       *x509.CertPool
+	modTimes map[string]time.Time
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewCertReloader loads the files named in cfg and checks them for
+// changes every interval, they are never reloaded when it's negative
+func NewCertReloader(cfg TLSConfig, interval time.Duration) (*CertReloader, error) {
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	r := &CertReloader{
+		cfg:      cfg,
+		interval: interval,
+		logger:   zap.L().Named("tls"),
+		shutdown: make(chan struct{}),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		r.wg.Add(1)
+		go r.watch()
+	}
+	return r, nil
+}
+
+// Reload reads the files, the current certificate and CA
+// are kept if any of them can't be read or parsed
+func (r *CertReloader) Reload() error {
+	modTimes, err := r.stat()
+	if err != nil {
+		return err
+	}
+	var cert *tls.Certificate
+	if r.cfg.CertFile != "" && r.cfg.KeyFile != "" {
+		c, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	var ca *x509.CertPool
+	if r.cfg.CAFile != "" {
+		b, err := ioutil.ReadFile(r.cfg.CAFile)
+		if err != nil {
+			return err
+		}
+		ca = x509.NewCertPool()
+		if ok := ca.AppendCertsFromPEM(b); !ok {
+			return fmt.Errorf("failed to parse root certificate: %q", r.cfg.CAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = cert
+	r.ca = ca
+	r.modTimes = modTimes
+	return nil
+}
+
+// TLSConfig returns a config that reads the certificate and CA last
+// loaded on every handshake. A client config verifies the server
+// itself, since its RootCAs can't be swapped once it's in use
+func (r *CertReloader) TLSConfig() *tls.Config {
+	if r.cfg.Server {
+		return &tls.Config{
+			GetConfigForClient: r.serverConfig,
+		}
+	}
+	return &tls.Config{
+		ServerName:           r.cfg.ServerAddress,
+		GetClientCertificate: r.clientCertificate,
+		// the chain is verified against the current CA in verifyServer
+		InsecureSkipVerify: true,
+		VerifyConnection:   r.verifyServer,
+	}
+}
+
+func (r *CertReloader) serverConfig(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c := &tls.Config{}
+	if r.cert != nil {
+		c.Certificates = []tls.Certificate{*r.cert}
+	}
+	if r.ca != nil {
+		c.ClientCAs = r.ca
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	// keep negotiating HTTP/2 for gRPC, which the config
+	// returned here would otherwise drop
+	for _, proto := range hello.SupportedProtos {
+		if proto == "h2" {
+			c.NextProtos = []string{proto}
+		}
+	}
+	return c, nil
+}
+
+func (r *CertReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return r.cert, nil
+}
+
+func (r *CertReloader) verifyServer(cs tls.ConnectionState) error {
+	r.mu.RLock()
+	ca := r.ca
+	r.mu.RUnlock()
+
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         ca,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// Close stops watching the files
+func (r *CertReloader) Close() error {
+	close(r.shutdown)
+	r.wg.Wait()
+	return nil
+}
+
+func (r *CertReloader) stat() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	for _, file := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if file == "" {
+			continue
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = fi.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (r *CertReloader) watch() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.shutdown:
+			return
+		case <-ticker.C:
+			modTimes, err := r.stat()
+			if err != nil {
+				r.logger.Error("failed to stat certificates", zap.Error(err))
+				continue
+			}
+			if !r.changed(modTimes) {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				r.logger.Error("failed to reload certificates", zap.Error(err))
+				continue
+			}
+			r.logger.Info("reloaded certificates", zap.String("cert", r.cfg.CertFile))
+		}
+	}
+}
+
+func (r *CertReloader) changed(modTimes map[string]time.Time) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for file, t := range modTimes {
+		if !t.Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
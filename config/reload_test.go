@@ -0,0 +1,153 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := func(name string) TLSConfig {
+		return TLSConfig{
+			CertFile: filepath.Join(dir, name+".pem"),
+			KeyFile:  filepath.Join(dir, name+"-key.pem"),
+			CAFile:   filepath.Join(dir, "ca.pem"),
+		}
+	}
+	serverFiles, clientFiles := files("server"), files("client")
+	serverFiles.Server = true
+	clientFiles.ServerAddress = "127.0.0.1"
+
+	// issue writes a fresh CA and certificates signed by it
+	issue := func(serial int64) *x509.Certificate {
+		ca, caKey := newCert(t, serial, "ca", nil, nil)
+		writePEM(t, serverFiles.CAFile, "CERTIFICATE", ca.Raw)
+		for _, f := range []TLSConfig{serverFiles, clientFiles} {
+			cert, key := newCert(t, serial, filepath.Base(f.CertFile), ca, caKey)
+			writePEM(t, f.CertFile, "CERTIFICATE", cert.Raw)
+			b, err := x509.MarshalECPrivateKey(key)
+			require.NoError(t, err)
+			writePEM(t, f.KeyFile, "EC PRIVATE KEY", b)
+		}
+		return ca
+	}
+	oldCA := issue(1)
+
+	server, err := NewCertReloader(serverFiles, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := NewCertReloader(clientFiles, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer client.Close()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", server.TLSConfig())
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	dial := func() *tls.Conn {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), client.TLSConfig())
+		require.NoError(t, err)
+		require.NoError(t, conn.Handshake())
+		return conn
+	}
+	echo := func(conn *tls.Conn) {
+		_, err := conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		b := make([]byte, 4)
+		_, err = io.ReadFull(conn, b)
+		require.NoError(t, err)
+	}
+
+	before := dial()
+	defer before.Close()
+	require.Equal(t, int64(1), before.ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+
+	// rotate to a new CA, new handshakes pick it up
+	// and established connections carry on
+	time.Sleep(10 * time.Millisecond)
+	issue(2)
+	require.Eventually(t, func() bool {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), client.TLSConfig())
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64() == 2
+	}, time.Second, 20*time.Millisecond)
+	echo(before)
+
+	after := dial()
+	defer after.Close()
+	echo(after)
+
+	// the old CA is no longer trusted
+	roots := x509.NewCertPool()
+	roots.AddCert(oldCA)
+	_, err = tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		RootCAs:    roots,
+		ServerName: "127.0.0.1",
+	})
+	require.Error(t, err)
+}
+
+func newCert(t *testing.T, serial int64, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	} else {
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func writePEM(t *testing.T, file, typ string, b []byte) {
+	t.Helper()
+	f, err := os.Create(file)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, pem.Encode(f, &pem.Block{Type: typ, Bytes: b}))
+}
@@ -0,0 +1,81 @@
+package discovery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedGossip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldKey := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	newKey := base64.StdEncoding.EncodeToString(append([]byte{1}, make([]byte, 31)...))
+
+	var members []*Membership
+	newMember := func(keyring bool) (*Membership, error) {
+		addr := fmt.Sprintf("127.0.0.1:%d", getFreePort())
+		c := Config{
+			NodeName: fmt.Sprintf("%d", len(members)),
+			BindAddr: addr,
+			Tags:     map[string]string{"rpc_addr": addr},
+		}
+		if keyring {
+			c.KeyringFile = filepath.Join(dir, c.NodeName+".json")
+			b, err := json.Marshal([]string{oldKey})
+			require.NoError(t, err)
+			require.NoError(t, ioutil.WriteFile(c.KeyringFile, b, 0600))
+		}
+		if len(members) > 0 {
+			c.StartJoinAddrs = []string{members[0].BindAddr}
+		}
+		return New(&handler{}, c)
+	}
+	for i := 0; i < 2; i++ {
+		m, err := newMember(true)
+		require.NoError(t, err)
+		defer m.Leave()
+		members = append(members, m)
+	}
+	require.Eventually(t, func() bool {
+		return len(members[0].Members()) == 2
+	}, 3*time.Second, 100*time.Millisecond)
+
+	// a member without the key can't join
+	outsider, err := newMember(false)
+	require.NoError(t, err)
+	defer outsider.Leave()
+	time.Sleep(500 * time.Millisecond)
+	require.Len(t, members[0].Members(), 2)
+	require.Len(t, outsider.Members(), 1)
+	_, err = outsider.ListKeys()
+	require.Equal(t, errNoEncryption, err)
+
+	// rotate the key across the cluster
+	_, err = members[0].InstallKey(newKey)
+	require.NoError(t, err)
+	_, err = members[0].UseKey(newKey)
+	require.NoError(t, err)
+	_, err = members[0].RemoveKey(oldKey)
+	require.NoError(t, err)
+
+	resp, err := members[1].ListKeys()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{newKey: 2}, resp.Keys)
+
+	// the new keyring is written back to each member's file
+	b, err := ioutil.ReadFile(members[1].KeyringFile)
+	require.NoError(t, err)
+	var keys []string
+	require.NoError(t, json.Unmarshal(b, &keys))
+	require.Equal(t, []string{newKey}, keys)
+}
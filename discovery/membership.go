@@ -1,9 +1,14 @@
 package discovery
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 
+	"github.com/hashicorp/memberlist"
 	"github.com/hashicorp/raft"
 	"github.com/hashicorp/serf/serf"
 	"go.uber.org/zap"
@@ -14,6 +19,11 @@ type Config struct {
 	BindAddr       string
 	Tags           map[string]string
 	StartJoinAddrs []string
+	// KeyringFile holds a JSON list of base64 encoded keys gossip is
+	// encrypted with, the first is used to encrypt. Members without
+	// a key are rejected, and changes made through the keyring
+	// methods are written back to the file
+	KeyringFile string
 }
 
 type Handler interface {
@@ -54,6 +64,13 @@ func (m *Membership) setupSerf() error {
 	config.EventCh = m.events
 	config.Tags = m.Tags
 	config.NodeName = m.NodeName
+	if m.KeyringFile != "" {
+		config.MemberlistConfig.Keyring, err = loadKeyring(m.KeyringFile)
+		if err != nil {
+			return err
+		}
+		config.KeyringFile = m.KeyringFile
+	}
 	m.serf, err = serf.Create(config)
 	if err != nil {
 		return err
@@ -113,6 +130,63 @@ func (m *Membership) Leave() error {
 	return m.serf.Leave()
 }
 
+var errNoEncryption = errors.New("gossip encryption is not enabled")
+
+// InstallKey adds a key to the keyring of every member
+func (m *Membership) InstallKey(key string) (*serf.KeyResponse, error) {
+	if !m.serf.EncryptionEnabled() {
+		return nil, errNoEncryption
+	}
+	return m.serf.KeyManager().InstallKey(key)
+}
+
+// UseKey makes an installed key the one every member encrypts with
+func (m *Membership) UseKey(key string) (*serf.KeyResponse, error) {
+	if !m.serf.EncryptionEnabled() {
+		return nil, errNoEncryption
+	}
+	return m.serf.KeyManager().UseKey(key)
+}
+
+// RemoveKey removes a key that isn't in use from every member
+func (m *Membership) RemoveKey(key string) (*serf.KeyResponse, error) {
+	if !m.serf.EncryptionEnabled() {
+		return nil, errNoEncryption
+	}
+	return m.serf.KeyManager().RemoveKey(key)
+}
+
+// ListKeys gathers the keys installed on every member
+func (m *Membership) ListKeys() (*serf.KeyResponse, error) {
+	if !m.serf.EncryptionEnabled() {
+		return nil, errNoEncryption
+	}
+	return m.serf.KeyManager().ListKeys()
+}
+
+func loadKeyring(file string) (*memberlist.Keyring, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var encoded []string
+	if err := json.Unmarshal(b, &encoded); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring %q: %w", file, err)
+	}
+	if len(encoded) == 0 {
+		return nil, fmt.Errorf("keyring %q has no keys", file)
+	}
+	keys := make([][]byte, 0, len(encoded))
+	for _, k := range encoded {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key in %q: %w", file, err)
+		}
+		keys = append(keys, key)
+	}
+	return memberlist.NewKeyring(keys, keys[0])
+}
+
 func (m *Membership) logError(err error, message string, member serf.Member) {
 	log := m.logger.Error
 	if err == raft.ErrNotLeader {
@@ -0,0 +1,101 @@
+package distributed
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/michael-diggin/yass/backup"
+)
+
+// ErrNotEmpty is returned when restoring a backup into a cluster
+// that has already been written to
+var ErrNotEmpty = errors.New("distributed: only a cluster that has never been written to can be restored")
+
+// Backup takes a snapshot of the FSM, or uses the latest one when
+// nothing has changed since, and writes it to w as a backup archive
+func (ydb *YassDB) Backup(w io.Writer) error {
+	var (
+		meta *raft.SnapshotMeta
+		r    io.ReadCloser
+		err  error
+	)
+	f := ydb.raft.Snapshot()
+	switch err = f.Error(); err {
+	case nil:
+		meta, r, err = f.Open()
+	case raft.ErrNothingNewToSnapshot:
+		meta, r, err = ydb.latestSnapshot()
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return backup.Write(w, backup.Meta{
+		Index:     meta.Index,
+		Term:      meta.Term,
+		Size:      meta.Size,
+		CreatedAt: time.Now().UTC(),
+	}, r)
+}
+
+func (ydb *YassDB) latestSnapshot() (*raft.SnapshotMeta, io.ReadCloser, error) {
+	snapshots, err := ydb.snapshots.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, nil, raft.ErrNothingNewToSnapshot
+	}
+	return ydb.snapshots.Open(snapshots[0].ID)
+}
+
+// RestoreBackup seeds a brand-new cluster with a backup archive,
+// taken with the same keyring. It must be called on the leader
+func (ydb *YassDB) RestoreBackup(r io.ReadSeeker) error {
+	written, err := ydb.written()
+	if err != nil {
+		return err
+	}
+	if written {
+		return ErrNotEmpty
+	}
+	meta, snapshot, err := backup.Read(r)
+	if err != nil {
+		return err
+	}
+	return ydb.raft.Restore(&raft.SnapshotMeta{
+		Version: raft.SnapshotVersionMax,
+		Index:   meta.Index,
+		Term:    meta.Term,
+		Size:    meta.Size,
+	}, snapshot, time.Minute)
+}
+
+// written reports whether the cluster has a snapshot
+// or a command in its Raft log
+func (ydb *YassDB) written() (bool, error) {
+	snapshots, err := ydb.snapshots.List()
+	if err != nil || len(snapshots) > 0 {
+		return len(snapshots) > 0, err
+	}
+	first, err := ydb.logStore.FirstIndex()
+	if err != nil || first == 0 {
+		return false, err
+	}
+	last, err := ydb.logStore.LastIndex()
+	if err != nil {
+		return false, err
+	}
+	for i := first; i <= last; i++ {
+		var entry raft.Log
+		if err := ydb.logStore.GetLog(i, &entry); err != nil {
+			return false, err
+		}
+		if entry.Type == raft.LogCommand {
+			return true, nil
+		}
+	}
+	return false, nil
+}
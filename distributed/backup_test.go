@@ -0,0 +1,98 @@
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/backup"
+	"github.com/michael-diggin/yass/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	src := newSingleNode(t)
+	ctx := context.Background()
+	_, err := src.CreateNamespace(ctx, &api.Namespace{Name: "team-a"})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = src.Set(ctx, &api.Record{Id: fmt.Sprintf("key-%d", i), Value: []byte("v")})
+		require.NoError(t, err)
+	}
+	_, err = src.Set(ctx, &api.Record{Namespace: "team-a", Id: "key", Value: []byte("ns")})
+	require.NoError(t, err)
+
+	var archive bytes.Buffer
+	require.NoError(t, src.Backup(&archive))
+	// with nothing new since, the latest snapshot is backed up again
+	var again bytes.Buffer
+	require.NoError(t, src.Backup(&again))
+	meta, _, err := backup.Read(bytes.NewReader(archive.Bytes()))
+	require.NoError(t, err)
+	metaAgain, _, err := backup.Read(bytes.NewReader(again.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, meta.Index, metaAgain.Index)
+	require.Equal(t, meta.Size, metaAgain.Size)
+
+	dst := newSingleNode(t)
+	require.NoError(t, dst.RestoreBackup(bytes.NewReader(archive.Bytes())))
+	for i := 0; i < 10; i++ {
+		rec, err := dst.Get(ctx, fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		require.Equal(t, []byte("v"), rec.Value)
+	}
+	rec, err := dst.Get(ctx, kv.Key("team-a", "key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("ns"), rec.Value)
+	require.Len(t, dst.ListNamespaces(), 1)
+
+	// the restored cluster carries on taking writes
+	_, err = dst.Set(ctx, &api.Record{Id: "key-10", Value: []byte("v")})
+	require.NoError(t, err)
+
+	err = dst.RestoreBackup(bytes.NewReader(archive.Bytes()))
+	require.Equal(t, ErrNotEmpty, err)
+
+	// a cluster whose keys have all been deleted has still been written to
+	empty := newSingleNode(t)
+	_, err = empty.Set(ctx, &api.Record{Id: "key", Value: []byte("v")})
+	require.NoError(t, err)
+	_, err = empty.Set(ctx, &api.Record{Id: "key", Deleted: true})
+	require.NoError(t, err)
+	require.Equal(t, 0, empty.Len())
+	err = empty.RestoreBackup(bytes.NewReader(archive.Bytes()))
+	require.Equal(t, ErrNotEmpty, err)
+}
+
+// newSingleNode returns a bootstrapped single node cluster
+func newSingleNode(t *testing.T) *YassDB {
+	t.Helper()
+	datadir, err := ioutil.TempDir("", "distributed-test")
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", getFreePort()))
+	require.NoError(t, err)
+
+	config := Config{}
+	config.Raft.StreamLayer = NewStreamLayer(ln, nil, nil)
+	config.Raft.LocalID = raft.ServerID("0")
+	config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+	config.Raft.ElectionTimeout = 50 * time.Millisecond
+	config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.Raft.CommitTimeout = 5 * time.Millisecond
+	config.Raft.Bootstrap = true
+	db, err := NewYassDB(datadir, config)
+	require.NoError(t, err)
+	require.NoError(t, db.WaitForLeader(3*time.Second))
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(datadir)
+	})
+	return db
+}
@@ -0,0 +1,40 @@
+package distributed
+
+import (
+	"context"
+	"errors"
+
+	"github.com/michael-diggin/yass/api"
+)
+
+// batchError is returned by the FSM when a record in a batch
+// can't be set, the records before it have been
+type batchError struct {
+	applied int
+	err     error
+}
+
+func (e batchError) Error() string {
+	return e.err.Error()
+}
+
+// SetBatch sets the records, in order, through a single Raft entry.
+// It stops at the first record that fails and returns how many
+// were set before it, and the Raft index of the entry
+func (ydb *YassDB) SetBatch(ctx context.Context, records []*api.Record) (int, uint64, error) {
+	_, index, err := ydb.Apply(ctx, BatchSetRequestType, &api.BatchSetRequest{Records: records})
+	var batchErr batchError
+	if errors.As(err, &batchErr) {
+		return batchErr.applied, index, batchErr.err
+	}
+	if err != nil {
+		return 0, index, err
+	}
+	return len(records), index, nil
+}
+
+// Records returns the local records in every namespace, sorted by
+// namespace then id, that come after the given key
+func (ydb *YassDB) Records(afterNamespace, afterID string) []*api.Record {
+	return ydb.db.Records(afterNamespace, afterID)
+}
@@ -0,0 +1,45 @@
+package distributed
+
+import (
+	"context"
+	"testing"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSetBatch(t *testing.T) {
+	db := newSingleNode(t)
+
+	ctx := context.Background()
+	_, err := db.CreateNamespace(ctx, &api.Namespace{Name: "team-a", MaxKeys: 2})
+	require.NoError(t, err)
+	n, _, err := db.SetBatch(ctx, []*api.Record{
+		{Id: "b", Value: []byte("2")},
+		{Id: "a", Value: []byte("1")},
+		{Namespace: "team-a", Id: "x", Value: []byte("3")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	// the batch stops at the record over the namespace's quota
+	n, _, err = db.SetBatch(ctx, []*api.Record{
+		{Namespace: "team-a", Id: "y", Value: []byte("4")},
+		{Namespace: "team-a", Id: "z", Value: []byte("5")},
+		{Id: "c", Value: []byte("6")},
+	})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Equal(t, 1, n)
+
+	var keys []string
+	for _, record := range db.Records("", "") {
+		keys = append(keys, record.Namespace+"/"+record.Id)
+	}
+	require.Equal(t, []string{"/a", "/b", "team-a/x", "team-a/y"}, keys)
+
+	records := db.Records("", "b")
+	require.Len(t, records, 2)
+	require.Equal(t, "x", records[0].Id)
+}
@@ -2,15 +2,27 @@ package distributed
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"github.com/michael-diggin/yass/acl"
 	"github.com/michael-diggin/yass/api"
 	"github.com/michael-diggin/yass/kv"
+	"github.com/michael-diggin/yass/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -18,21 +30,46 @@ type RequestType uint8
 
 const (
 	SetRequestType RequestType = iota
+	DropNamespaceRequestType
+	BatchSetRequestType
+	RetainRequestType
 )
 
+// tracedRequest is set on the request type of entries that carry
+// a length prefixed W3C traceparent ahead of the request
+const tracedRequest RequestType = 1 << 7
+
+var tracer = otel.Tracer("github.com/michael-diggin/yass/distributed")
+
 type Config struct {
 	kvConfig kv.Config
-	Raft     struct {
+	// Cipher encrypts the store's log, the segmented Raft log
+	// and so the snapshots taken from the store's log
+	Cipher log.Cipher
+	// Retention bounds the store's log, see YassDB.Retain
+	Retention log.Retention
+	// Compression compresses the new segments of the store's log
+	// and of the segmented Raft log
+	Compression log.Codec
+	Raft        struct {
 		raft.Config
 		StreamLayer *StreamLayer
 		Bootstrap   bool
+		// LogStore selects where the Raft log is kept,
+		// either BoltLogStore (the default) or SegmentedLogStore
+		LogStore string
 	}
 }
 
 type YassDB struct {
-	config Config
-	db     *kv.DB
-	raft   *raft.Raft
+	config    Config
+	db        *kv.DB
+	acls      *acl.Rules
+	raft      *raft.Raft
+	logStore  raft.LogStore
+	snapshots raft.SnapshotStore
+	// done stops the loops the leader runs
+	done chan struct{}
 }
 
 func NewYassDB(datadir string, config Config) (*YassDB, error) {
@@ -43,30 +80,78 @@ func NewYassDB(datadir string, config Config) (*YassDB, error) {
 	if err := ydb.setUpRaft(datadir); err != nil {
 		return nil, err
 	}
+	ydb.done = make(chan struct{})
+	go ydb.leaderEvery(expiryInterval, ydb.done, ydb.Expire, "failed to delete expired keys")
+	if config.Retention != (log.Retention{}) {
+		go ydb.leaderEvery(retentionInterval, ydb.done, ydb.Retain, "failed to apply log retention")
+	}
 	return ydb, nil
 }
 
+// leaderEvery calls fn every interval while this node is the leader
+func (ydb *YassDB) leaderEvery(interval time.Duration, done chan struct{}, fn func(context.Context) error, msg string) {
+	logger := zap.L().Named("distributed")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if !ydb.IsLeader() {
+				continue
+			}
+			if err := fn(context.Background()); err != nil {
+				logger.Error(msg, zap.Error(err))
+			}
+		}
+	}
+}
+
 func (ydb *YassDB) setUpDB(datadir string) (err error) {
 	plogDir := filepath.Join(datadir, "plog")
 	if err := os.MkdirAll(plogDir, 0755); err != nil {
 		return err
 	}
+	ydb.config.kvConfig.Cipher = ydb.config.Cipher
+	ydb.config.kvConfig.Retention = ydb.config.Retention
+	ydb.config.kvConfig.Compression = ydb.config.Compression
 	ydb.db, err = kv.NewDB(plogDir, ydb.config.kvConfig)
-	return err
+	if err != nil {
+		return err
+	}
+	ydb.acls = acl.NewRules()
+	return ydb.acls.Reset(ydb.db.Scan(acl.KeyPrefix))
 }
 
 func (ydb *YassDB) setUpRaft(datadir string) (err error) {
-	fsm := &fsm{db: ydb.db}
+	fsm := &fsm{db: ydb.db, acls: ydb.acls}
 
 	logDir := filepath.Join(datadir, "raft")
 	if err := os.MkdirAll(logDir, 0755); err != nil {
 		return err
 	}
 
-	raftStore, err := raftboltdb.NewBoltStore(filepath.Join(datadir, "raft", "log"))
+	var raftStore raft.LogStore
+	switch ydb.config.Raft.LogStore {
+	case "", BoltLogStore:
+		raftStore, err = raftboltdb.NewBoltStore(filepath.Join(datadir, "raft", "log"))
+	case SegmentedLogStore:
+		raftLogDir := filepath.Join(datadir, "raft", "plog")
+		if err := os.MkdirAll(raftLogDir, 0755); err != nil {
+			return err
+		}
+		raftStore, err = newLogStore(raftLogDir, log.Config{
+			Cipher:      ydb.config.Cipher,
+			Compression: ydb.config.Compression,
+		})
+	default:
+		err = fmt.Errorf("unknown raft log store: %q", ydb.config.Raft.LogStore)
+	}
 	if err != nil {
 		return err
 	}
+	ydb.logStore = raftStore
 	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(datadir, "raft", "stable"))
 	if err != nil {
 		return err
@@ -76,6 +161,7 @@ func (ydb *YassDB) setUpRaft(datadir string) (err error) {
 	if err != nil {
 		return err
 	}
+	ydb.snapshots = snapshotStore
 	maxPool := 5
 	timeout := 10 * time.Second
 	transport := raft.NewNetworkTransport(ydb.config.Raft.StreamLayer,
@@ -122,39 +208,112 @@ func (ydb *YassDB) setUpRaft(datadir string) (err error) {
 	return err
 }
 
-func (ydb *YassDB) Set(record *api.Record) error {
-	_, err := ydb.Apply(SetRequestType, &api.SetRequest{Record: record})
-	return err
+// Set sets the record, returning the Raft index it was applied at
+func (ydb *YassDB) Set(ctx context.Context, record *api.Record) (uint64, error) {
+	_, index, err := ydb.Apply(ctx, SetRequestType, &api.SetRequest{Record: record})
+	return index, err
 }
 
-func (ydb *YassDB) Get(id string) (*api.Record, error) {
-	return ydb.db.Get(id)
+// SetIf sets the record only while its key's latest version
+// is at the offset
+func (ydb *YassDB) SetIf(ctx context.Context, record *api.Record, offset uint64) (uint64, error) {
+	_, index, err := ydb.Apply(ctx, SetRequestType, &api.SetRequest{Record: record, Condition: &api.Condition{Offset: offset}})
+	return index, err
 }
 
-func (ydb *YassDB) Apply(reqType RequestType, req proto.Message) (interface{}, error) {
-	var buf bytes.Buffer
-	_, err := buf.Write([]byte{byte(reqType)})
+func (ydb *YassDB) Get(ctx context.Context, id string) (*api.Record, error) {
+	return ydb.db.Get(ctx, id)
+}
+
+// GetAt returns the local version of a key that was
+// current at an offset of the store's log
+func (ydb *YassDB) GetAt(ctx context.Context, id string, offset uint64) (*api.Record, error) {
+	return ydb.db.GetAt(ctx, id, offset)
+}
+
+// History returns the local versions of a key newest first
+func (ydb *YassDB) History(ctx context.Context, id string, limit int) ([]*api.Record, error) {
+	return ydb.db.History(ctx, id, limit)
+}
+
+// Scan returns the local records in the default namespace
+// whose keys start with prefix, sorted by key
+func (ydb *YassDB) Scan(prefix string) []*api.Record {
+	return ydb.db.Scan(prefix)
+}
+
+// Apply applies the request through Raft, returning the FSM's
+// response and the Raft index it was applied at
+func (ydb *YassDB) Apply(ctx context.Context, reqType RequestType, req proto.Message) (interface{}, uint64, error) {
+	ctx, span := tracer.Start(ctx, "YassDB.Apply",
+		trace.WithAttributes(attribute.Int("yass.request_type", int(reqType))))
+	defer span.End()
+
+	res, index, err := ydb.apply(ctx, reqType, req)
 	if err != nil {
-		return nil, err
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return res, index, err
+}
+
+func (ydb *YassDB) apply(ctx context.Context, reqType RequestType, req proto.Message) (interface{}, uint64, error) {
+	var buf bytes.Buffer
+	carrier := traceCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	if traceparent := carrier.Get("traceparent"); traceparent != "" {
+		buf.WriteByte(byte(reqType | tracedRequest))
+		if err := binary.Write(&buf, binary.BigEndian, uint16(len(traceparent))); err != nil {
+			return nil, 0, err
+		}
+		buf.WriteString(traceparent)
+	} else {
+		buf.WriteByte(byte(reqType))
 	}
 	b, err := proto.Marshal(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	_, err = buf.Write(b)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	future := ydb.raft.Apply(buf.Bytes(), 10*time.Second)
 	if future.Error() != nil {
-		return nil, future.Error()
+		return nil, 0, future.Error()
 	}
+	index := future.Index()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("raft.index", int64(index)))
 	res := future.Response()
 	if err, ok := res.(error); ok {
-		return nil, err
+		return nil, index, err
+	}
+	return res, index, nil
+}
+
+// SetACL adds or replaces an ACL rule across the cluster
+func (ydb *YassDB) SetACL(ctx context.Context, rule *api.ACLRule) (uint64, error) {
+	record, err := acl.Encode(rule)
+	if err != nil {
+		return 0, err
 	}
-	return res, nil
+	return ydb.Set(ctx, record)
+}
+
+// DeleteACL removes an ACL rule across the cluster
+func (ydb *YassDB) DeleteACL(ctx context.Context, subject, prefix string) (uint64, error) {
+	return ydb.Set(ctx, &api.Record{Id: acl.Key(subject, prefix), Deleted: true})
+}
+
+// ListACLs returns the ACL rules applied to this node
+func (ydb *YassDB) ListACLs() []*api.ACLRule {
+	return ydb.acls.List()
+}
+
+// Allowed reports whether the ACL rules grant subject
+// read or write access to the key
+func (ydb *YassDB) Allowed(subject, key string, write bool) bool {
+	return ydb.acls.Allowed(subject, key, write)
 }
 
 func (ydb *YassDB) Join(id, addr string) error {
@@ -181,8 +340,96 @@ func (ydb *YassDB) Join(id, addr string) error {
 }
 
 func (ydb *YassDB) Leave(id string) error {
-	removeFuture := ydb.raft.RemoveServer(raft.ServerID(id), 0, 0)
-	return removeFuture.Error()
+	return ydb.RemoveServer(id)
+}
+
+// Len returns the number of keys in the local store
+func (ydb *YassDB) Len() int {
+	return ydb.db.Len()
+}
+
+// LogStats returns the size of the local store's persistent log
+func (ydb *YassDB) LogStats() log.Stats {
+	return ydb.db.LogStats()
+}
+
+// IsLeader reports whether this node is the Raft leader
+func (ydb *YassDB) IsLeader() bool {
+	return ydb.raft.State() == raft.Leader
+}
+
+// Servers returns the servers in the current Raft configuration
+func (ydb *YassDB) Servers() ([]raft.Server, error) {
+	future := ydb.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	return future.Configuration().Servers, nil
+}
+
+// Leader returns the address of the current Raft leader
+func (ydb *YassDB) Leader() string {
+	return string(ydb.raft.Leader())
+}
+
+// Ready reports whether there is a Raft leader and the local FSM
+// has applied every entry this node knows to be committed
+func (ydb *YassDB) Ready() bool {
+	if ydb.raft.Leader() == "" {
+		return false
+	}
+	stats := ydb.raft.Stats()
+	commit, err := strconv.ParseUint(stats["commit_index"], 10, 64)
+	if err != nil {
+		return false
+	}
+	applied, err := strconv.ParseUint(stats["applied_index"], 10, 64)
+	if err != nil {
+		return false
+	}
+	return applied >= commit && stats["fsm_pending"] == "0"
+}
+
+// Stats returns Raft's internal statistics
+func (ydb *YassDB) Stats() map[string]string {
+	return ydb.raft.Stats()
+}
+
+// AddVoter adds a voting server to the Raft configuration
+func (ydb *YassDB) AddVoter(id, addr string) error {
+	f := ydb.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
+	return f.Error()
+}
+
+// RemoveServer removes a server from the Raft configuration
+func (ydb *YassDB) RemoveServer(id string) error {
+	f := ydb.raft.RemoveServer(raft.ServerID(id), 0, 0)
+	return f.Error()
+}
+
+// Snapshot takes a snapshot of the FSM and returns the
+// Raft index it was taken at
+func (ydb *YassDB) Snapshot() (uint64, error) {
+	f := ydb.raft.Snapshot()
+	if err := f.Error(); err != nil {
+		return 0, err
+	}
+	meta, r, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return meta.Index, nil
+}
+
+// TransferLeadership hands leadership to the given server,
+// or to the most up to date voter if id is empty
+func (ydb *YassDB) TransferLeadership(id, addr string) error {
+	if id == "" {
+		return ydb.raft.LeadershipTransfer().Error()
+	}
+	f := ydb.raft.LeadershipTransferToServer(raft.ServerID(id), raft.ServerAddress(addr))
+	return f.Error()
 }
 
 func (ydb *YassDB) WaitForLeader(timeout time.Duration) error {
@@ -202,9 +449,18 @@ func (ydb *YassDB) WaitForLeader(timeout time.Duration) error {
 }
 
 func (ydb *YassDB) Close() error {
+	if ydb.done != nil {
+		close(ydb.done)
+		ydb.done = nil
+	}
 	f := ydb.raft.Shutdown()
 	if f.Error() != nil {
 		return f.Error()
 	}
+	if c, ok := ydb.logStore.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
 	return ydb.db.Close()
 }
@@ -1,6 +1,7 @@
 package distributed
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -12,6 +13,10 @@ import (
 	"github.com/hashicorp/raft"
 	"github.com/michael-diggin/yass/api"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestMultipleNodes(t *testing.T) {
@@ -36,6 +41,10 @@ func TestMultipleNodes(t *testing.T) {
 		config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
 		config.Raft.CommitTimeout = 5 * time.Millisecond
 		config.Raft.Bootstrap = (i == 0)
+		// run a mixed cluster so both Raft log stores are exercised
+		if i%2 == 1 {
+			config.Raft.LogStore = SegmentedLogStore
+		}
 
 		db, err := NewYassDB(datadir, config)
 		require.NoError(t, err, "failed on %d", i)
@@ -49,19 +58,25 @@ func TestMultipleNodes(t *testing.T) {
 		dbs = append(dbs, db)
 	}
 
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	ctx, span := otel.Tracer("test").Start(context.Background(), "request")
+
 	records := []*api.Record{
 		{Id: "rec-1", Value: []byte("first")},
 		{Id: "rec-2", Value: []byte("second")},
 	}
 	for _, record := range records {
-		err := dbs[0].Set(record)
+		_, err := dbs[0].Set(ctx, record)
 		require.NoError(t, err)
 	}
+	span.End()
 
 	for _, record := range records {
 		require.Eventually(t, func() bool {
 			for j := 0; j < len(dbs); j++ {
-				rec, err := dbs[j].Get(record.Id)
+				rec, err := dbs[j].Get(context.Background(), record.Id)
 				if err != nil {
 					return false
 				}
@@ -73,22 +88,65 @@ func TestMultipleNodes(t *testing.T) {
 		}, 500*time.Millisecond, 50*time.Millisecond)
 	}
 
-	err := dbs[0].Leave("1")
+	require.Eventually(t, func() bool {
+		for _, db := range dbs {
+			if !db.Ready() {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 50*time.Millisecond)
+
+	// every node's apply joins the trace of the request
+	require.Eventually(t, func() bool {
+		applies := 0
+		for _, s := range sr.Ended() {
+			if s.Name() == "fsm.Apply" && s.SpanContext().TraceID() == span.SpanContext().TraceID() {
+				applies++
+			}
+		}
+		return applies == nodeCount*len(records)
+	}, 500*time.Millisecond, 50*time.Millisecond)
+
+	// ACL rules are replicated to every node
+	rule := &api.ACLRule{Subject: "client", Prefix: "rec-", Read: true}
+	_, err := dbs[0].SetACL(context.Background(), rule)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		for _, db := range dbs {
+			if !db.Allowed("client", "rec-1", false) || db.Allowed("client", "rec-1", true) {
+				return false
+			}
+		}
+		return true
+	}, 500*time.Millisecond, 50*time.Millisecond)
+	_, err = dbs[0].DeleteACL(context.Background(), rule.Subject, rule.Prefix)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		for _, db := range dbs {
+			if len(db.ListACLs()) != 0 {
+				return false
+			}
+		}
+		return true
+	}, 500*time.Millisecond, 50*time.Millisecond)
+
+	err = dbs[0].Leave("1")
 	require.NoError(t, err)
 
 	time.Sleep(50 * time.Millisecond)
 
-	err = dbs[0].Set(&api.Record{Id: "rec-3", Value: []byte("third")})
+	_, err = dbs[0].Set(context.Background(), &api.Record{Id: "rec-3", Value: []byte("third")})
 	require.NoError(t, err)
 
 	time.Sleep(50 * time.Millisecond)
 
-	rc, err := dbs[1].Get("rec-3")
+	rc, err := dbs[1].Get(context.Background(), "rec-3")
 	require.Error(t, err)
 	require.IsType(t, api.ErrNotFound{}, err)
 	require.Nil(t, rc)
 
-	rc, err = dbs[2].Get("rec-3")
+	rc, err = dbs[2].Get(context.Background(), "rec-3")
 	require.NoError(t, err)
 	require.Equal(t, []byte("third"), rc.Value)
 }
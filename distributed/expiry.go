@@ -0,0 +1,25 @@
+package distributed
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/michael-diggin/yass/api"
+)
+
+// expiryInterval is how often the leader deletes expired keys
+var expiryInterval = 10 * time.Second
+
+// Expire deletes the expired keys across the cluster,
+// each only while it's unchanged since it expired
+func (ydb *YassDB) Expire(ctx context.Context) error {
+	for _, record := range ydb.db.Expired(time.Now()) {
+		tombstone := &api.Record{Namespace: record.Namespace, Id: record.Id, Deleted: true}
+		_, err := ydb.SetIf(ctx, tombstone, record.Offset)
+		if err != nil && !errors.As(err, &api.ErrConflict{}) {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,44 +2,161 @@ package distributed
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"io"
+	"strings"
 
 	"github.com/hashicorp/raft"
+	"github.com/michael-diggin/yass/acl"
 	"github.com/michael-diggin/yass/api"
 	"github.com/michael-diggin/yass/kv"
+	"github.com/michael-diggin/yass/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 )
 
 type fsm struct {
-	db *kv.DB
+	db   *kv.DB
+	acls *acl.Rules
 }
 
 var _ raft.FSM = (*fsm)(nil)
 
+// errMalformedEntry is returned for a log entry too short to hold
+// its request type or traceparent
+var errMalformedEntry = errors.New("malformed raft log entry")
+
 func (f *fsm) Apply(record *raft.Log) interface{} {
 	buf := record.Data
+	if len(buf) < 1 {
+		return errMalformedEntry
+	}
 	reqType := RequestType(buf[0])
+	buf = buf[1:]
+	ctx := context.Background()
+	if reqType&tracedRequest != 0 {
+		reqType &^= tracedRequest
+		if len(buf) < 2 {
+			return errMalformedEntry
+		}
+		n := int(binary.BigEndian.Uint16(buf))
+		if len(buf) < 2+n {
+			return errMalformedEntry
+		}
+		carrier := traceCarrier{"traceparent": string(buf[2 : 2+n])}
+		ctx = propagation.TraceContext{}.Extract(ctx, carrier)
+		buf = buf[2+n:]
+	}
+	ctx, span := tracer.Start(ctx, "fsm.Apply", trace.WithAttributes(
+		attribute.Int64("raft.index", int64(record.Index)),
+		attribute.Int("yass.request_type", int(reqType)),
+	))
+	defer span.End()
+
 	switch reqType {
 	case SetRequestType:
-		return f.append(buf[1:])
+		return f.append(ctx, buf)
+	case DropNamespaceRequestType:
+		return f.dropNamespace(ctx, buf)
+	case BatchSetRequestType:
+		return f.appendBatch(ctx, buf)
+	case RetainRequestType:
+		return f.retain(ctx, buf)
 	}
 	return nil
 }
 
-func (f *fsm) append(buf []byte) interface{} {
+func (f *fsm) append(ctx context.Context, buf []byte) interface{} {
 	var req api.SetRequest
 	err := proto.Unmarshal(buf, &req)
 	if err != nil {
 		return err
 	}
-	return f.db.Set(req.Record)
+	if req.Condition != nil {
+		if err := f.db.Check(req.Record, req.Condition.Offset); err != nil {
+			return err
+		}
+	}
+	return f.set(ctx, req.Record)
+}
+
+// appendBatch sets the records in order, stopping at the first
+// that fails with a batchError saying how many were set
+func (f *fsm) appendBatch(ctx context.Context, buf []byte) interface{} {
+	var req api.BatchSetRequest
+	err := proto.Unmarshal(buf, &req)
+	if err != nil {
+		return err
+	}
+	for i, record := range req.Records {
+		if err := f.set(ctx, record); err != nil {
+			return batchError{applied: i, err: err}
+		}
+	}
+	return nil
+}
+
+func (f *fsm) set(ctx context.Context, record *api.Record) error {
+	if record.Namespace != "" {
+		if err := f.checkQuota(ctx, record); err != nil {
+			return err
+		}
+	}
+	if err := f.db.Set(ctx, record); err != nil {
+		return err
+	}
+	if record.Namespace == "" && strings.HasPrefix(record.Id, acl.KeyPrefix) {
+		return f.acls.Apply(record)
+	}
+	return nil
+}
+
+// checkQuota returns an error if the record's namespace doesn't
+// exist or writing the record would take it over its quota
+func (f *fsm) checkQuota(ctx context.Context, record *api.Record) error {
+	ns, err := getNamespace(ctx, f.db, record.Namespace)
+	if err != nil {
+		return err
+	}
+	usage := f.db.UsageWith(record)
+	if (ns.MaxKeys > 0 && usage.Keys > ns.MaxKeys) || (ns.MaxBytes > 0 && usage.Bytes > ns.MaxBytes) {
+		return api.ErrQuotaExceeded{Namespace: ns.Name}
+	}
+	return nil
+}
+
+func (f *fsm) dropNamespace(ctx context.Context, buf []byte) interface{} {
+	var req api.DropNamespaceRequest
+	err := proto.Unmarshal(buf, &req)
+	if err != nil {
+		return err
+	}
+	if _, err := getNamespace(ctx, f.db, req.Name); err != nil {
+		return err
+	}
+	if err := f.db.Set(ctx, &api.Record{Id: namespaceKey(req.Name), Deleted: true}); err != nil {
+		return err
+	}
+	return f.db.DropNamespace(ctx, req.Name)
+}
+
+func (f *fsm) retain(ctx context.Context, buf []byte) interface{} {
+	var req api.RetainRequest
+	err := proto.Unmarshal(buf, &req)
+	if err != nil {
+		return err
+	}
+	return f.db.Retain(ctx, req.Offset)
 }
 
 var _ raft.FSMSnapshot = (*snapshot)(nil)
 
 type snapshot struct {
-	reader io.Reader
+	reader io.ReadCloser
 }
 
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
@@ -55,7 +172,11 @@ func (s *snapshot) Persist(sink raft.SnapshotSink) error {
 	return sink.Close()
 }
 
-func (s *snapshot) Release() {}
+// Release closes the log's reader, which keeps the segments
+// it reads from being removed by retention
+func (s *snapshot) Release() {
+	s.reader.Close()
+}
 
 func (f *fsm) Restore(r io.ReadCloser) error {
 	lenWidth := 8
@@ -74,8 +195,8 @@ func (f *fsm) Restore(r io.ReadCloser) error {
 		if _, err := io.CopyN(&buf, r, size); err != nil {
 			return err
 		}
-		record := &api.Record{}
-		if err := proto.Unmarshal(buf.Bytes(), record); err != nil {
+		record, err := log.Unmarshal(f.db.LogConfig.Cipher, buf.Bytes())
+		if err != nil {
 			return err
 		}
 		if i == 0 {
@@ -84,10 +205,29 @@ func (f *fsm) Restore(r io.ReadCloser) error {
 				return err
 			}
 		}
-		if err := f.db.Set(record); err != nil {
+		if err := f.db.Set(context.Background(), record); err != nil {
 			return err
 		}
 		buf.Reset()
 	}
-	return nil
+	return f.acls.Reset(f.db.Scan(acl.KeyPrefix))
+}
+
+// traceCarrier holds the trace context carried in a Raft entry
+type traceCarrier map[string]string
+
+func (c traceCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c traceCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c traceCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
 }
@@ -0,0 +1,118 @@
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/michael-diggin/yass/acl"
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/keyring"
+	"github.com/michael-diggin/yass/kv"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEncryptedSnapshotRestore(t *testing.T) {
+	k, err := keyring.New(make([]byte, 32))
+	require.NoError(t, err)
+	src, teardown := newTestFSM(t, k)
+	defer teardown()
+	dst, teardown := newTestFSM(t, k)
+	defer teardown()
+
+	ctx := context.Background()
+	require.NoError(t, src.db.Set(ctx, &api.Record{Id: "key", Value: []byte("secret")}))
+
+	snap, err := src.Snapshot()
+	require.NoError(t, err)
+	sink := &snapshotSink{}
+	require.NoError(t, snap.Persist(sink))
+	require.NotContains(t, sink.String(), "secret")
+
+	require.NoError(t, dst.Restore(ioutil.NopCloser(sink)))
+	rec, err := dst.db.Get(ctx, "key")
+	require.NoError(t, err)
+	require.Equal(t, []byte("secret"), rec.Value)
+}
+
+func TestRetainApply(t *testing.T) {
+	k, err := keyring.New(make([]byte, 32))
+	require.NoError(t, err)
+	src, teardown := newTestFSM(t, k)
+	defer teardown()
+	dst, teardown := newTestFSM(t, k)
+	defer teardown()
+
+	ctx := context.Background()
+	for _, id := range []string{"key-1", "key-2", "key-3"} {
+		require.NoError(t, src.db.Set(ctx, &api.Record{Id: id, Value: []byte("v")}))
+	}
+	b, err := proto.Marshal(&api.RetainRequest{Offset: 2})
+	require.NoError(t, err)
+	res := src.Apply(&raft.Log{Data: append([]byte{byte(RetainRequestType)}, b...)})
+	require.Nil(t, res)
+	require.Equal(t, 2, src.db.Len())
+
+	// a snapshot holds the evicted keys while their segment is kept,
+	// the node restoring it evicts them again
+	snap, err := src.Snapshot()
+	require.NoError(t, err)
+	sink := &snapshotSink{}
+	require.NoError(t, snap.Persist(sink))
+	require.NoError(t, dst.Restore(ioutil.NopCloser(sink)))
+	require.Equal(t, 2, dst.db.Len())
+	require.Equal(t, uint64(2), dst.db.Retained())
+	_, err = dst.db.Get(ctx, "key-2")
+	require.Error(t, err)
+	_, err = dst.db.Get(ctx, "key-3")
+	require.NoError(t, err)
+}
+
+func TestApplyMalformedEntry(t *testing.T) {
+	f, teardown := newTestFSM(t, nil)
+	defer teardown()
+
+	traced := byte(SetRequestType | tracedRequest)
+	for name, data := range map[string][]byte{
+		"empty":               {},
+		"no length":           {traced, 0},
+		"short traceparent":   {traced, 0, 8, 'a'},
+		"missing traceparent": {traced, 0xff, 0xff},
+	} {
+		require.Equal(t, errMalformedEntry, f.Apply(&raft.Log{Data: data}), name)
+	}
+}
+
+func newTestFSM(t *testing.T, k *keyring.Keyring) (*fsm, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "fsm-test")
+	require.NoError(t, err)
+	db, err := kv.NewDB(dir, kv.Config{Cipher: k})
+	require.NoError(t, err)
+	return &fsm{db: db, acls: acl.NewRules()}, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+type snapshotSink struct {
+	bytes.Buffer
+}
+
+func (s *snapshotSink) ID() string {
+	return "test"
+}
+
+func (s *snapshotSink) Cancel() error {
+	return nil
+}
+
+func (s *snapshotSink) Close() error {
+	return nil
+}
+
+var _ raft.SnapshotSink = (*snapshotSink)(nil)
@@ -0,0 +1,127 @@
+package distributed
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/log"
+)
+
+const (
+	// BoltLogStore keeps the Raft log in a BoltDB file
+	BoltLogStore = "bolt"
+	// SegmentedLogStore keeps the Raft log in the project's segmented log
+	SegmentedLogStore = "log"
+)
+
+var _ raft.LogStore = (*logStore)(nil)
+
+// logStore implements raft.LogStore on top of log.Log,
+// using the log offset of each record as its Raft index
+type logStore struct {
+	*log.Log
+}
+
+func newLogStore(dir string, c log.Config) (*logStore, error) {
+	if c.Segment.MaxStoreBytes == 0 {
+		c.Segment.MaxStoreBytes = 16 << 20
+	}
+	// Raft indexes start at 1
+	c.Segment.InitialOffset = 1
+	l, err := log.NewLog(dir, c)
+	if err != nil {
+		return nil, err
+	}
+	return &logStore{l}, nil
+}
+
+// FirstIndex returns zero when the log is empty, as Raft expects
+func (l *logStore) FirstIndex() (uint64, error) {
+	first, err := l.LowestOffset()
+	if err != nil {
+		return 0, err
+	}
+	last, err := l.HighestOffset()
+	if err != nil {
+		return 0, err
+	}
+	if last < first {
+		return 0, nil
+	}
+	return first, nil
+}
+
+func (l *logStore) LastIndex() (uint64, error) {
+	return l.HighestOffset()
+}
+
+func (l *logStore) GetLog(index uint64, out *raft.Log) error {
+	rec, err := l.Read(index)
+	if err != nil {
+		if errors.As(err, &api.ErrOffsetOutOfRange{}) {
+			return raft.ErrLogNotFound
+		}
+		return err
+	}
+	out.Index = rec.Offset
+	out.Term = rec.Term
+	out.Type = raft.LogType(rec.Type)
+	out.Data = rec.Value
+	return nil
+}
+
+func (l *logStore) StoreLog(record *raft.Log) error {
+	return l.StoreLogs([]*raft.Log{record})
+}
+
+func (l *logStore) StoreLogs(records []*raft.Log) error {
+	for _, record := range records {
+		last, err := l.LastIndex()
+		if err != nil {
+			return err
+		}
+		if record.Index > last+1 {
+			// a snapshot has been installed past the end of the log,
+			// restart the log from the snapshot's next index
+			l.Config.Segment.InitialOffset = record.Index
+			if err := l.Reset(); err != nil {
+				return err
+			}
+		}
+		off, err := l.Append(&api.Record{
+			Value: record.Data,
+			Term:  record.Term,
+			Type:  uint32(record.Type),
+		})
+		if err != nil {
+			return err
+		}
+		if off != record.Index {
+			return fmt.Errorf("stored log at offset %d, expected index %d", off, record.Index)
+		}
+	}
+	return nil
+}
+
+// DeleteRange removes the records between min and max inclusive.
+// Raft only deletes from the start of the log when compacting, and
+// from the end when discarding conflicting entries
+func (l *logStore) DeleteRange(min, max uint64) error {
+	first, err := l.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := l.LastIndex()
+	if err != nil {
+		return err
+	}
+	if max >= last {
+		return l.TruncateFrom(min)
+	}
+	if min <= first {
+		return l.Truncate(max)
+	}
+	return fmt.Errorf("cannot delete range [%d, %d] from the middle of the log", min, max)
+}
@@ -0,0 +1,116 @@
+package distributed
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"github.com/michael-diggin/yass/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logstore-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := log.Config{}
+	c.Segment.MaxStoreBytes = 16
+	store, err := newLogStore(dir, c)
+	require.NoError(t, err)
+
+	first, err := store.FirstIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), first)
+	last, err := store.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), last)
+
+	var records []*raft.Log
+	for i := uint64(1); i <= 6; i++ {
+		records = append(records, &raft.Log{
+			Index: i,
+			Term:  2,
+			Type:  raft.LogCommand,
+			Data:  []byte(fmt.Sprintf("entry-%d", i)),
+		})
+	}
+	require.NoError(t, store.StoreLogs(records))
+
+	first, err = store.FirstIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), first)
+	last, err = store.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), last)
+
+	var read raft.Log
+	require.NoError(t, store.GetLog(3, &read))
+	require.Equal(t, *records[2], read)
+	require.Equal(t, raft.ErrLogNotFound, store.GetLog(7, &read))
+
+	// discard a conflicting suffix and replace it
+	require.NoError(t, store.DeleteRange(5, 6))
+	last, err = store.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), last)
+	replacement := &raft.Log{Index: 5, Term: 3, Data: []byte("replaced")}
+	require.NoError(t, store.StoreLog(replacement))
+	require.NoError(t, store.GetLog(5, &read))
+	require.Equal(t, *replacement, read)
+
+	// compact a prefix
+	require.NoError(t, store.DeleteRange(1, 2))
+	require.Equal(t, raft.ErrLogNotFound, store.GetLog(1, &read))
+	require.NoError(t, store.GetLog(4, &read))
+
+	// storing past the end of the log restarts it
+	require.NoError(t, store.StoreLog(&raft.Log{Index: 20, Term: 4}))
+	first, err = store.FirstIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), first)
+	last, err = store.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), last)
+
+	require.NoError(t, store.Close())
+}
+
+func BenchmarkLogStore(b *testing.B) {
+	stores := map[string]func(dir string) (raft.LogStore, error){
+		BoltLogStore: func(dir string) (raft.LogStore, error) {
+			return raftboltdb.NewBoltStore(filepath.Join(dir, "log"))
+		},
+		SegmentedLogStore: func(dir string) (raft.LogStore, error) {
+			return newLogStore(dir, log.Config{})
+		},
+	}
+	for name, newStore := range stores {
+		b.Run(name, func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "logstore-bench")
+			require.NoError(b, err)
+			defer os.RemoveAll(dir)
+			store, err := newStore(dir)
+			require.NoError(b, err)
+
+			data := make([]byte, 256)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 1; i <= b.N; i++ {
+				err := store.StoreLog(&raft.Log{
+					Index: uint64(i),
+					Term:  1,
+					Type:  raft.LogCommand,
+					Data:  data,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package distributed
+
+import (
+	"context"
+	"errors"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/kv"
+	"google.golang.org/protobuf/proto"
+)
+
+// namespacePrefix starts the keys namespaces are stored under
+const namespacePrefix = kv.ReservedPrefix + "ns/"
+
+func namespaceKey(name string) string {
+	return namespacePrefix + name
+}
+
+func getNamespace(ctx context.Context, db *kv.DB, name string) (*api.Namespace, error) {
+	record, err := db.Get(ctx, namespaceKey(name))
+	if errors.As(err, &api.ErrNotFound{}) {
+		return nil, api.ErrNamespaceNotFound{Name: name}
+	}
+	if err != nil {
+		return nil, err
+	}
+	ns := &api.Namespace{}
+	return ns, proto.Unmarshal(record.Value, ns)
+}
+
+// CreateNamespace adds a namespace, or updates its quotas
+// if it already exists
+func (ydb *YassDB) CreateNamespace(ctx context.Context, ns *api.Namespace) (uint64, error) {
+	b, err := proto.Marshal(ns)
+	if err != nil {
+		return 0, err
+	}
+	return ydb.Set(ctx, &api.Record{Id: namespaceKey(ns.Name), Value: b})
+}
+
+// DropNamespace removes a namespace and all of its keys
+// in a single Raft entry
+func (ydb *YassDB) DropNamespace(ctx context.Context, name string) (uint64, error) {
+	_, index, err := ydb.Apply(ctx, DropNamespaceRequestType, &api.DropNamespaceRequest{Name: name})
+	return index, err
+}
+
+// ListNamespaces returns the namespaces sorted by name,
+// along with what each holds on this node
+func (ydb *YassDB) ListNamespaces() []*api.NamespaceUsage {
+	var namespaces []*api.NamespaceUsage
+	for _, record := range ydb.db.Scan(namespacePrefix) {
+		ns := &api.Namespace{}
+		if err := proto.Unmarshal(record.Value, ns); err != nil {
+			continue
+		}
+		usage := ydb.db.Usage(ns.Name)
+		namespaces = append(namespaces, &api.NamespaceUsage{
+			Namespace: ns,
+			Keys:      usage.Keys,
+			Bytes:     usage.Bytes,
+		})
+	}
+	return namespaces
+}
@@ -0,0 +1,107 @@
+package distributed
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/kv"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNamespaceQuotas(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "namespace-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(datadir)
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", getFreePort()))
+	require.NoError(t, err)
+	config := Config{}
+	config.Raft.StreamLayer = NewStreamLayer(ln, nil, nil)
+	config.Raft.LocalID = raft.ServerID("0")
+	config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+	config.Raft.ElectionTimeout = 50 * time.Millisecond
+	config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.Raft.CommitTimeout = 5 * time.Millisecond
+	config.Raft.Bootstrap = true
+	db, err := NewYassDB(datadir, config)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.WaitForLeader(3*time.Second))
+
+	ctx := context.Background()
+	_, err = db.Set(ctx, &api.Record{Namespace: "team-a", Id: "key", Value: []byte("v")})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = db.CreateNamespace(ctx, &api.Namespace{Name: "team-a", MaxKeys: 2})
+
+	require.NoError(t, err)
+	for _, id := range []string{"key-1", "key-2"} {
+		_, err = db.Set(ctx, &api.Record{Namespace: "team-a", Id: id, Value: []byte("v")})
+		require.NoError(t, err)
+	}
+	// overwriting a key doesn't add to the key count
+	_, err = db.Set(ctx, &api.Record{Namespace: "team-a", Id: "key-1", Value: []byte("w")})
+	require.NoError(t, err)
+	_, err = db.Set(ctx, &api.Record{Namespace: "team-a", Id: "key-3", Value: []byte("v")})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// the same id in the default namespace is a different key
+	_, err = db.Set(ctx, &api.Record{Id: "key-1", Value: []byte("default")})
+	require.NoError(t, err)
+	rec, err := db.Get(ctx, kv.Key("team-a", "key-1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("w"), rec.Value)
+
+	namespaces := db.ListNamespaces()
+	require.Len(t, namespaces, 1)
+	require.Equal(t, uint64(2), namespaces[0].Keys)
+	require.Equal(t, uint64(12), namespaces[0].Bytes)
+
+	_, err = db.DropNamespace(ctx, "team-a")
+
+	require.NoError(t, err)
+	require.Empty(t, db.ListNamespaces())
+	_, err = db.Get(ctx, kv.Key("team-a", "key-1"))
+	require.Equal(t, codes.NotFound, status.Code(err))
+	rec, err = db.Get(ctx, "key-1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("default"), rec.Value)
+
+	_, err = db.DropNamespace(ctx, "team-a")
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestExpiredKeysFreeQuota(t *testing.T) {
+	db := newSingleNode(t)
+
+	ctx := context.Background()
+	_, err := db.CreateNamespace(ctx, &api.Namespace{Name: "team-a", MaxKeys: 2})
+	require.NoError(t, err)
+	expiresAt := time.Now().Add(100 * time.Millisecond).UnixNano()
+	for _, id := range []string{"key-1", "key-2"} {
+		_, err = db.Set(ctx, &api.Record{Namespace: "team-a", Id: id, Value: []byte("v"), ExpiresAt: expiresAt})
+		require.NoError(t, err)
+	}
+	_, err = db.Set(ctx, &api.Record{Namespace: "team-a", Id: "key-3", Value: []byte("v")})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	time.Sleep(time.Until(time.Unix(0, expiresAt)))
+	_, err = db.Set(ctx, &api.Record{Namespace: "team-a", Id: "key-2", Value: []byte("w")})
+	require.NoError(t, err)
+	require.NoError(t, db.Expire(ctx))
+	require.Equal(t, uint64(1), db.ListNamespaces()[0].Keys)
+	_, err = db.Set(ctx, &api.Record{Namespace: "team-a", Id: "key-3", Value: []byte("v")})
+	require.NoError(t, err)
+	rec, err := db.Get(ctx, kv.Key("team-a", "key-2"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("w"), rec.Value)
+}
@@ -0,0 +1,23 @@
+package distributed
+
+import (
+	"context"
+	"time"
+
+	"github.com/michael-diggin/yass/api"
+)
+
+// retentionInterval is how often the leader checks the store's
+// log against its Retention
+var retentionInterval = time.Minute
+
+// Retain applies the store's log retention across the cluster,
+// from the offset the leader's log is retained from
+func (ydb *YassDB) Retain(ctx context.Context) error {
+	offset := ydb.db.RetainedOffset()
+	if offset <= ydb.db.Retained() {
+		return nil
+	}
+	_, _, err := ydb.Apply(ctx, RetainRequestType, &api.RetainRequest{Offset: offset})
+	return err
+}
@@ -0,0 +1,112 @@
+package keyring
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/michael-diggin/yass/log"
+)
+
+var _ log.Cipher = (*Keyring)(nil)
+
+// Keyring encrypts with AES-GCM using its active key, and decrypts
+// with any of its keys so the active key can be rotated.
+// A key is identified by the first four bytes of its SHA-256 hash
+type Keyring struct {
+	aeads  map[uint32]cipher.AEAD
+	active uint32
+}
+
+// New returns a keyring of AES-128, 192 or 256 keys,
+// the first of which is active
+func New(keys ...[]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keyring needs at least one key")
+	}
+	k := &Keyring{aeads: make(map[uint32]cipher.AEAD)}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := KeyID(key)
+		k.aeads[id] = aead
+		if i == 0 {
+			k.active = id
+		}
+	}
+	return k, nil
+}
+
+// Load reads a keyring from a file holding one base64 encoded
+// key per line, the first is active. Blank lines and lines
+// starting with '#' are ignored
+func Load(file string) (*Keyring, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys [][]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key in %q: %w", file, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return New(keys...)
+}
+
+// KeyID returns the ID records sealed with key are stored with
+func KeyID(key []byte) uint32 {
+	sum := sha256.Sum256(key)
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// Seal encrypts plaintext with the active key,
+// the random nonce is prepended to the ciphertext
+func (k *Keyring) Seal(plaintext []byte) (uint32, []byte, error) {
+	aead := k.aeads[k.active]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return 0, nil, err
+	}
+	return k.active, aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts ciphertext sealed with the key `keyID`
+func (k *Keyring) Open(keyID uint32, ciphertext []byte) ([]byte, error) {
+	aead, ok := k.aeads[keyID]
+	if !ok {
+		return nil, fmt.Errorf("no key with ID %08x in keyring", keyID)
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,68 @@
+package keyring
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealAndOpen(t *testing.T) {
+	oldKey := make([]byte, 32)
+	newKey := make([]byte, 32)
+	newKey[0] = 1
+
+	old, err := New(oldKey)
+	require.NoError(t, err)
+	oldID, sealed, err := old.Seal([]byte("hello world"))
+	require.NoError(t, err)
+	require.Equal(t, KeyID(oldKey), oldID)
+	require.NotContains(t, string(sealed), "hello world")
+
+	// the rotated keyring seals with the new key and opens with both
+	rotated, err := New(newKey, oldKey)
+	require.NoError(t, err)
+	p, err := rotated.Open(oldID, sealed)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), p)
+
+	newID, sealed, err := rotated.Seal([]byte("hello again"))
+	require.NoError(t, err)
+	require.Equal(t, KeyID(newKey), newID)
+	_, err = old.Open(newID, sealed)
+	require.Error(t, err)
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = rotated.Open(newID, sealed)
+	require.Error(t, err)
+
+	_, err = New([]byte("short"))
+	require.Error(t, err)
+	_, err = New()
+	require.Error(t, err)
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key := make([]byte, 16)
+	key[3] = 7
+	file := filepath.Join(dir, "keys")
+	contents := "# active key first\n" + base64.StdEncoding.EncodeToString(key) + "\n\n"
+	require.NoError(t, ioutil.WriteFile(file, []byte(contents), 0600))
+
+	k, err := Load(file)
+	require.NoError(t, err)
+	id, _, err := k.Seal([]byte("x"))
+	require.NoError(t, err)
+	require.Equal(t, KeyID(key), id)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("not base64!"), 0600))
+	_, err = Load(file)
+	require.Error(t, err)
+}
@@ -1,18 +1,32 @@
 package kv
 
 import (
+	"context"
 	"errors"
 	"io"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/michael-diggin/yass/api"
 	"github.com/michael-diggin/yass/log"
 )
 
+// ReservedPrefix starts the keys the cluster uses for its own state,
+// clients are not allowed to write them
+const ReservedPrefix = "_yass/"
+
 // DB is a struct containing the in memory KV store
 // as well as the persistent log
 type DB struct {
-	data      map[string]*api.Record
+	data  map[string]*api.Record
+	usage map[string]Usage
+	// heads are the offsets of every key's latest version,
+	// including the keys whose latest version deleted them
+	heads map[string]uint64
+	// retained is the offset the log was last retained from
+	retained  uint64
 	mu        sync.RWMutex
 	plog      *log.Log
 	LogConfig log.Config
@@ -20,46 +34,246 @@ type DB struct {
 
 type Config struct {
 	logConfig log.Config
+	// Cipher encrypts the records in the persistent log when set
+	Cipher log.Cipher
+	// Retention bounds the persistent log, see DB.RetainedOffset
+	Retention log.Retention
+	// Compression compresses the records of the persistent log's new
+	// segments, see log.Config.Compression
+	Compression log.Codec
 }
 
 func NewDB(dir string, c Config) (*DB, error) {
+	c.logConfig.Cipher = c.Cipher
+	c.logConfig.Retention = c.Retention
+	c.logConfig.Compression = c.Compression
 	plog, err := log.NewLog(dir, c.logConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	store, err := resetOnStartUp(plog)
+	store, heads, retained, err := resetOnStartUp(plog)
 	if err != nil {
 		return nil, err
 	}
-	return &DB{data: store, mu: sync.RWMutex{}, plog: plog, LogConfig: c.logConfig}, nil
+	return &DB{data: store, usage: usage(store), heads: heads, retained: retained, mu: sync.RWMutex{}, plog: plog, LogConfig: c.logConfig}, nil
+}
+
+// Key returns the key a record is held under, keys in the
+// default namespace are held under their id
+func Key(namespace, id string) string {
+	if namespace == "" {
+		return id
+	}
+	return namespace + "\x00" + id
 }
 
-func (db *DB) Set(record *api.Record) error {
+// Usage is the number of keys in a namespace and the
+// bytes taken by their ids and values
+type Usage struct {
+	Keys  uint64
+	Bytes uint64
+}
+
+func recordBytes(record *api.Record) uint64 {
+	return uint64(len(record.Id) + len(record.Value))
+}
+
+func (db *DB) Set(ctx context.Context, record *api.Record) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	return db.set(ctx, record)
+}
 
-	off, err := db.plog.Append(record)
+// SetIf sets the record only while its key's latest version
+// is at the offset, returning ErrConflict otherwise
+func (db *DB) SetIf(ctx context.Context, record *api.Record, offset uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.check(record, offset); err != nil {
+		return err
+	}
+	return db.set(ctx, record)
+}
+
+// Check returns ErrConflict unless the record's key's
+// latest version is at the offset
+func (db *DB) Check(record *api.Record, offset uint64) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.check(record, offset)
+}
+
+func (db *DB) check(record *api.Record, offset uint64) error {
+	if old, ok := db.data[Key(record.Namespace, record.Id)]; !ok || old.Offset != offset {
+		return api.ErrConflict{Id: record.Id, Offset: offset}
+	}
+	return nil
+}
+
+func (db *DB) set(ctx context.Context, record *api.Record) error {
+	// each version points back to the one before it
+	record.PrevOffset, record.HasPrev = 0, false
+	if prev, ok := db.heads[Key(record.Namespace, record.Id)]; ok && !record.Dropped {
+		record.PrevOffset, record.HasPrev = prev, true
+	}
+	off, err := db.plog.AppendContext(ctx, record)
 	if err != nil {
 		return err
 	}
 	record.Offset = off
-	db.data[record.Id] = record
+	track(db.heads, record)
+	if record.Dropped {
+		delete(db.usage, record.Namespace)
+	} else {
+		db.usage[record.Namespace] = db.usageWith(record)
+	}
+	apply(db.data, record)
+	if off, ok := retain(db.data, db.heads, record); ok {
+		db.retained = off
+		db.usage = usage(db.data)
+	}
 	return nil
 }
 
-func (db *DB) Get(id string) (*api.Record, error) {
+func (db *DB) Get(ctx context.Context, key string) (*api.Record, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	record, ok := db.data[id]
-	if !ok {
-		return nil, api.ErrNotFound{Id: id}
+	record, ok := db.data[key]
+	if !ok || expired(record, time.Now()) {
+		return nil, notFound(key)
 	}
 	return record, nil
 }
 
+func notFound(key string) error {
+	id := key
+	if i := strings.IndexByte(key, 0); i >= 0 {
+		id = key[i+1:]
+	}
+	return api.ErrNotFound{Id: id}
+}
+
+// Expired returns the records whose keys have expired by now
+func (db *DB) Expired(now time.Time) []*api.Record {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var records []*api.Record
+	for _, record := range db.data {
+		if expired(record, now) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// expired reports whether the record's key has expired by now,
+// expired keys are kept until the leader deletes them
+func expired(record *api.Record, now time.Time) bool {
+	return record.ExpiresAt != 0 && now.UnixNano() >= record.ExpiresAt
+}
+
+// Usage returns what a namespace holds
+func (db *DB) Usage(namespace string) Usage {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.usage[namespace]
+}
+
+// UsageWith returns what the record's namespace would hold
+// once the record is written
+func (db *DB) UsageWith(record *api.Record) Usage {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.usageWith(record)
+}
+
+func (db *DB) usageWith(record *api.Record) Usage {
+	u := db.usage[record.Namespace]
+	if old, ok := db.data[Key(record.Namespace, record.Id)]; ok {
+		u.Keys--
+		u.Bytes -= recordBytes(old)
+	}
+	if !record.Deleted {
+		u.Keys++
+		u.Bytes += recordBytes(record)
+	}
+	return u
+}
+
+// DropNamespace removes every key in a namespace
+// by appending a single tombstone for it
+func (db *DB) DropNamespace(ctx context.Context, namespace string) error {
+	return db.Set(ctx, &api.Record{Namespace: namespace, Dropped: true})
+}
+
+// Scan returns the unexpired records in the default namespace
+// whose keys start with prefix, sorted by key
+func (db *DB) Scan(prefix string) []*api.Record {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	now := time.Now()
+	var records []*api.Record
+	for id, record := range db.data {
+		if record.Namespace == "" && strings.HasPrefix(id, prefix) && !expired(record, now) {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Id < records[j].Id
+	})
+	return records
+}
+
+// Records returns the unexpired records outside ReservedPrefix,
+// sorted by namespace then id, that come after the given key
+func (db *DB) Records(afterNamespace, afterID string) []*api.Record {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	now := time.Now()
+	var records []*api.Record
+	for _, record := range db.data {
+		if record.Namespace == "" && strings.HasPrefix(record.Id, ReservedPrefix) {
+			continue
+		}
+		if expired(record, now) || !after(record, afterNamespace, afterID) {
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return after(records[j], records[i].Namespace, records[i].Id)
+	})
+	return records
+}
+
+// after reports whether the record's key sorts after the given key
+func after(record *api.Record, namespace, id string) bool {
+	if record.Namespace != namespace {
+		return record.Namespace > namespace
+	}
+	return record.Id > id
+}
+
+// Len returns the number of keys held
+func (db *DB) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.data)
+}
+
+// LogStats returns the size of the persistent log
+func (db *DB) LogStats() log.Stats {
+	return db.plog.Stats()
+}
+
 func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	if err := db.plog.Close(); err != nil {
 		return err
 	}
@@ -68,6 +282,8 @@ func (db *DB) Close() error {
 }
 
 func (db *DB) Clear() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	if err := db.plog.Remove(); err != nil {
 		return err
 	}
@@ -75,9 +291,16 @@ func (db *DB) Clear() error {
 	return nil
 }
 
-func resetOnStartUp(plog *log.Log) (map[string]*api.Record, error) {
+func resetOnStartUp(plog *log.Log) (map[string]*api.Record, map[string]uint64, uint64, error) {
 	store := make(map[string]*api.Record)
-	i := 0
+	heads := make(map[string]uint64)
+	var retained uint64
+	// the log starts after any segments dropped by retention
+	lowest, err := plog.LowestOffset()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	i := int(lowest)
 	done := false
 	for !done {
 		rec, err := plog.Read(uint64(i))
@@ -86,20 +309,75 @@ func resetOnStartUp(plog *log.Log) (map[string]*api.Record, error) {
 				done = true
 				continue
 			} else {
-				return nil, err
+				return nil, nil, 0, err
 			}
 		}
-		store[rec.Id] = rec
+		apply(store, rec)
+		track(heads, rec)
+		if off, ok := retain(store, heads, rec); ok {
+			retained = off
+		}
 		i++
 	}
-	return store, nil
+	return store, heads, retained, nil
+}
+
+// apply updates the keys held with a record read from the log
+func apply(store map[string]*api.Record, record *api.Record) {
+	switch {
+	case record.Dropped:
+		for key, rec := range store {
+			if rec.Namespace == record.Namespace {
+				delete(store, key)
+			}
+		}
+	case record.Deleted:
+		delete(store, Key(record.Namespace, record.Id))
+	default:
+		store[Key(record.Namespace, record.Id)] = record
+	}
+}
+
+// track updates the offsets of the keys' latest versions with a
+// record read from the log, a namespace's keys start a new history
+// once it's dropped
+func track(heads map[string]uint64, record *api.Record) {
+	if !record.Dropped {
+		heads[Key(record.Namespace, record.Id)] = record.Offset
+		return
+	}
+	for key := range heads {
+		namespace := ""
+		if i := strings.IndexByte(key, 0); i >= 0 {
+			namespace = key[:i]
+		}
+		if namespace == record.Namespace {
+			delete(heads, key)
+		}
+	}
+}
+
+func usage(store map[string]*api.Record) map[string]Usage {
+	usage := make(map[string]Usage)
+	for _, record := range store {
+		u := usage[record.Namespace]
+		u.Keys++
+		u.Bytes += recordBytes(record)
+		usage[record.Namespace] = u
+	}
+	return usage
 }
 
 func (db *DB) Restore() error {
 	db.data = make(map[string]*api.Record)
+	db.usage = make(map[string]Usage)
+	db.heads = make(map[string]uint64)
+	db.retained = 0
 	return db.plog.Reset()
 }
 
-func (db *DB) LogReader() io.Reader {
+// LogReader reads the entire persistent log, see log.Log.Reader.
+// It must be closed
+func (db *DB) LogReader() io.ReadCloser {
 	return db.plog.Reader()
 }
@@ -1,10 +1,12 @@
 package kv
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/michael-diggin/yass/api"
 	"github.com/michael-diggin/yass/log"
@@ -25,10 +27,10 @@ func TestKVDBSetAndGet(t *testing.T) {
 		Id:    key,
 		Value: []byte("hello world"),
 	}
-	err = db.Set(append)
+	err = db.Set(context.Background(), append)
 	require.NoError(t, err)
 
-	read, err := db.Get(key)
+	read, err := db.Get(context.Background(), key)
 	require.NoError(t, err)
 	require.Equal(t, append.Value, read.Value)
 
@@ -47,7 +49,7 @@ func TestKVDBNotFound(t *testing.T) {
 
 	key := "test-key"
 
-	read, err := db.Get(key)
+	read, err := db.Get(context.Background(), key)
 	require.Error(t, err)
 	require.Nil(t, read)
 	require.True(t, errors.As(err, &api.ErrNotFound{}))
@@ -79,7 +81,7 @@ func TestResetOnStartUp(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, uint64(1), off)
 
-	data, err := resetOnStartUp(plog)
+	data, _, _, err := resetOnStartUp(plog)
 	require.NoError(t, err)
 
 	require.Len(t, data, 2)
@@ -87,3 +89,86 @@ func TestResetOnStartUp(t *testing.T) {
 	require.Equal(t, appendTwo.Value, data[appendTwo.Id].Value)
 
 }
+
+func TestKVDBScanAndTombstones(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	db, err := NewDB(dir, Config{})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, id := range []string{"b/2", "a/1", "b/1"} {
+		require.NoError(t, db.Set(ctx, &api.Record{Id: id, Value: []byte(id)}))
+	}
+	require.NoError(t, db.Set(ctx, &api.Record{Id: "b/2", Deleted: true}))
+
+	records := db.Scan("b/")
+	require.Len(t, records, 1)
+	require.Equal(t, "b/1", records[0].Id)
+
+	_, err = db.Get(ctx, "b/2")
+	require.True(t, errors.As(err, &api.ErrNotFound{}))
+	require.NoError(t, db.Close())
+
+	// the tombstone is honoured when the log is replayed
+	db, err = NewDB(dir, Config{})
+	require.NoError(t, err)
+	require.Equal(t, 2, db.Len())
+	require.NoError(t, db.Close())
+}
+
+func TestKVDBNamespaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	db, err := NewDB(dir, Config{})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, db.Set(ctx, &api.Record{Namespace: "a", Id: "key", Value: []byte("abc")}))
+	require.NoError(t, db.Set(ctx, &api.Record{Namespace: "b", Id: "key", Value: []byte("b")}))
+	require.NoError(t, db.Set(ctx, &api.Record{Id: "key", Value: []byte("default")}))
+	require.Equal(t, Usage{Keys: 1, Bytes: 6}, db.Usage("a"))
+
+	next := &api.Record{Namespace: "a", Id: "key", Value: []byte("abcdef")}
+	require.Equal(t, Usage{Keys: 1, Bytes: 9}, db.UsageWith(next))
+
+	require.NoError(t, db.DropNamespace(ctx, "a"))
+	require.Equal(t, Usage{}, db.Usage("a"))
+	_, err = db.Get(ctx, Key("a", "key"))
+	require.True(t, errors.As(err, &api.ErrNotFound{}))
+	require.NoError(t, db.Close())
+
+	// the dropped namespace stays dropped when the log is replayed
+	db, err = NewDB(dir, Config{})
+	require.NoError(t, err)
+	require.Equal(t, 2, db.Len())
+	rec, err := db.Get(ctx, Key("b", "key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), rec.Value)
+	require.NoError(t, db.Close())
+}
+
+func TestKVDBExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	db, err := NewDB(dir, Config{})
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	require.NoError(t, db.Set(ctx, &api.Record{Id: "expired", ExpiresAt: now.Add(-time.Second).UnixNano()}))
+	require.NoError(t, db.Set(ctx, &api.Record{Id: "expiring", ExpiresAt: now.Add(time.Hour).UnixNano()}))
+
+	_, err = db.Get(ctx, "expired")
+	require.True(t, errors.As(err, &api.ErrNotFound{}))
+	_, err = db.Get(ctx, "expiring")
+	require.NoError(t, err)
+
+	records := db.Scan("expi")
+	require.Len(t, records, 1)
+	require.Equal(t, "expiring", records[0].Id)
+}
@@ -0,0 +1,78 @@
+package kv
+
+import (
+	"context"
+	"errors"
+
+	"github.com/michael-diggin/yass/api"
+)
+
+// History returns the versions of the key newest first, up to limit
+// when it's positive, back to the oldest version still in the log.
+// Deletes are returned as tombstones
+func (db *DB) History(ctx context.Context, key string, limit int) ([]*api.Record, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	off, ok := db.heads[key]
+	if !ok {
+		return nil, notFound(key)
+	}
+	var records []*api.Record
+	for off >= db.retained {
+		record, err := db.plog.Read(off)
+		if errors.As(err, &api.ErrOffsetOutOfRange{}) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+		if !record.HasPrev || (limit > 0 && len(records) == limit) {
+			break
+		}
+		off = record.PrevOffset
+	}
+	return records, nil
+}
+
+// GetAt returns the version of the key that was current at the offset,
+// it's not found if the key didn't exist or had been deleted then. An
+// ErrOffsetOutOfRange is returned when the offset is before the one
+// the log was retained from, as the version may be gone
+func (db *DB) GetAt(ctx context.Context, key string, offset uint64) (*api.Record, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if offset < db.retained {
+		return nil, api.ErrOffsetOutOfRange{Offset: offset}
+	}
+	off, ok := db.heads[key]
+	if !ok {
+		return nil, notFound(key)
+	}
+	for {
+		// versions before the retained offset are only read while
+		// their segment is kept, so they're treated as gone
+		if off < db.retained {
+			return nil, api.ErrOffsetOutOfRange{Offset: offset}
+		}
+		record, err := db.plog.Read(off)
+		if errors.As(err, &api.ErrOffsetOutOfRange{}) {
+			return nil, api.ErrOffsetOutOfRange{Offset: offset}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record.Offset <= offset {
+			if record.Deleted {
+				return nil, notFound(key)
+			}
+			return record, nil
+		}
+		if !record.HasPrev {
+			return nil, notFound(key)
+		}
+		off = record.PrevOffset
+	}
+}
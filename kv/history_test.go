@@ -0,0 +1,76 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c := Config{}
+	c.logConfig.Segment.MaxStoreBytes = 32
+	db, err := NewDB(dir, c)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, record := range []*api.Record{
+		{Id: "key", Value: []byte("v1")},
+		{Id: "other", Value: []byte("v1")},
+		{Id: "key", Value: []byte("v2")},
+		{Id: "key", Deleted: true},
+		{Id: "key", Value: []byte("v3")},
+		{Namespace: "team-a", Id: "key", Value: []byte("a1")},
+	} {
+		require.NoError(t, db.Set(ctx, record))
+	}
+
+	history, err := db.History(ctx, "key", 0)
+	require.NoError(t, err)
+	var offsets []uint64
+	for _, record := range history {
+		offsets = append(offsets, record.Offset)
+	}
+	require.Equal(t, []uint64{4, 3, 2, 0}, offsets)
+	require.True(t, history[1].Deleted)
+
+	record, err := db.GetAt(ctx, "key", 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("v1"), record.Value)
+	_, err = db.GetAt(ctx, "key", 3)
+	require.True(t, errors.As(err, &api.ErrNotFound{}))
+	record, err = db.GetAt(ctx, "key", 10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("v3"), record.Value)
+	_, err = db.GetAt(ctx, "other", 0)
+	require.True(t, errors.As(err, &api.ErrNotFound{}))
+
+	// the history is rebuilt from the log on start up
+	require.NoError(t, db.Close())
+	db, err = NewDB(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	history, err = db.History(ctx, "key", 2)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.NoError(t, db.Set(ctx, &api.Record{Id: "key", Value: []byte("v4")}))
+	history, err = db.History(ctx, "key", 0)
+	require.NoError(t, err)
+	require.Len(t, history, 5)
+
+	// a dropped namespace's keys start a new history
+	require.NoError(t, db.DropNamespace(ctx, "team-a"))
+	_, err = db.History(ctx, Key("team-a", "key"), 0)
+	require.True(t, errors.As(err, &api.ErrNotFound{}))
+	require.NoError(t, db.Set(ctx, &api.Record{Namespace: "team-a", Id: "key", Value: []byte("a2")}))
+	history, err = db.History(ctx, Key("team-a", "key"), 0)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+}
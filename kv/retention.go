@@ -0,0 +1,80 @@
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"strings"
+
+	"github.com/michael-diggin/yass/api"
+	"google.golang.org/protobuf/proto"
+)
+
+// retainedKey holds the offset the log was last retained from,
+// replaying it evicts the same keys Retain did
+const retainedKey = ReservedPrefix + "retained"
+
+// RetainedOffset returns the lowest offset the log keeps under
+// its Retention, the offset to pass to Retain
+func (db *DB) RetainedOffset() uint64 {
+	return db.plog.RetainedOffset()
+}
+
+// Retained returns the offset the log was last retained from,
+// versions of keys before it are gone
+func (db *DB) Retained() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.retained
+}
+
+// Retain evicts the keys whose last write was before the offset and
+// drops the log's segments that are wholly before it. Keys under
+// ReservedPrefix are appended again rather than evicted
+func (db *DB) Retain(ctx context.Context, offset uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if offset <= db.retained {
+		return nil
+	}
+	var kept []string
+	for key, record := range db.data {
+		if record.Offset < offset && record.Namespace == "" &&
+			strings.HasPrefix(record.Id, ReservedPrefix) && record.Id != retainedKey {
+			kept = append(kept, key)
+		}
+	}
+	sort.Strings(kept)
+	for _, key := range kept {
+		if err := db.set(ctx, proto.Clone(db.data[key]).(*api.Record)); err != nil {
+			return err
+		}
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, offset)
+	if err := db.set(ctx, &api.Record{Id: retainedKey, Value: value}); err != nil {
+		return err
+	}
+	return db.plog.Truncate(offset - 1)
+}
+
+// retain evicts the keys and the history before the offset
+// held by a retainedKey record, it returns false for other records
+func retain(store map[string]*api.Record, heads map[string]uint64, record *api.Record) (uint64, bool) {
+	if record.Namespace != "" || record.Id != retainedKey || len(record.Value) != 8 {
+		return 0, false
+	}
+	offset := binary.BigEndian.Uint64(record.Value)
+	for key, rec := range store {
+		if rec.Offset < offset {
+			delete(store, key)
+		}
+	}
+	for key, off := range heads {
+		if off < offset {
+			delete(heads, key)
+		}
+	}
+	return offset, true
+}
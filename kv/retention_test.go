@@ -0,0 +1,107 @@
+package kv
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c := Config{}
+	c.logConfig.Segment.MaxStoreBytes = 32
+	db, err := NewDB(dir, c)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	value := []byte("hello world, hello world")
+	for _, record := range []*api.Record{
+		{Id: "key-1", Value: value},
+		{Id: ReservedPrefix + "ns/team-a", Value: value},
+		{Namespace: "team-a", Id: "key-2", Value: value},
+	} {
+		require.NoError(t, db.Set(ctx, record))
+	}
+	require.Equal(t, uint64(0), db.RetainedOffset())
+
+	db.plog.Config.Retention.MaxBytes = 1
+	require.Equal(t, uint64(3), db.RetainedOffset())
+	require.NoError(t, db.Retain(ctx, db.RetainedOffset()))
+	require.Equal(t, uint64(3), db.Retained())
+	_, err = db.Get(ctx, "key-1")
+	require.Error(t, err)
+	require.Equal(t, Usage{}, db.Usage("team-a"))
+	// reserved keys are appended again rather than evicted
+	record, err := db.Get(ctx, ReservedPrefix+"ns/team-a")
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), record.Offset)
+	_, err = db.GetAt(ctx, "key-1", 2)
+	require.Equal(t, api.ErrOffsetOutOfRange{Offset: 2}, err)
+	lowest, err := db.plog.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lowest)
+
+	require.NoError(t, db.Set(ctx, &api.Record{Id: "key-3", Value: value}))
+	require.NoError(t, db.Close())
+
+	db, err = NewDB(dir, c)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Get(ctx, "key-3")
+	require.NoError(t, err)
+	_, err = db.Get(ctx, ReservedPrefix+"ns/team-a")
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), db.Retained())
+}
+
+func TestRetainIsDeterministic(t *testing.T) {
+	ctx := context.Background()
+	value := []byte("hello world, hello world")
+	// nodes roll their segments over at different points, but
+	// retaining from the same offset leaves them holding the same keys
+	var dbs []*DB
+	for _, maxStoreBytes := range []uint64{32, 1024} {
+		dir, err := ioutil.TempDir("", "retention-test")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		c := Config{}
+		c.logConfig.Segment.MaxStoreBytes = maxStoreBytes
+		db, err := NewDB(dir, c)
+		require.NoError(t, err)
+		for _, record := range []*api.Record{
+			{Id: "key-1", Value: value},
+			{Id: "key-2", Value: value},
+			{Id: ReservedPrefix + "ns/b", Value: value},
+			{Id: ReservedPrefix + "ns/a", Value: value},
+			{Id: "key-3", Value: value},
+		} {
+			require.NoError(t, db.Set(ctx, record))
+		}
+		require.NoError(t, db.Retain(ctx, 4))
+		require.NoError(t, db.Close())
+
+		db, err = NewDB(dir, c)
+		require.NoError(t, err)
+		defer db.Close()
+		dbs = append(dbs, db)
+	}
+	for _, db := range dbs {
+		require.Equal(t, 4, db.Len())
+		_, err := db.Get(ctx, "key-1")
+		require.Error(t, err)
+		_, err = db.Get(ctx, "key-2")
+		require.Error(t, err)
+		// the reserved keys are appended in order of their keys
+		record, err := db.Get(ctx, ReservedPrefix+"ns/a")
+		require.NoError(t, err)
+		require.Equal(t, uint64(5), record.Offset)
+		_, err = db.History(ctx, "key-2", 0)
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,69 @@
+package log
+
+import (
+	"errors"
+
+	"github.com/michael-diggin/yass/api"
+	"google.golang.org/protobuf/proto"
+)
+
+// Cipher encrypts records before they are written to a store
+// and decrypts them when they are read back
+type Cipher interface {
+	// Seal encrypts plaintext with the active key
+	// and returns the key's ID with the ciphertext
+	Seal(plaintext []byte) (keyID uint32, ciphertext []byte, err error)
+	// Open decrypts ciphertext sealed with the key `keyID`
+	Open(keyID uint32, ciphertext []byte) ([]byte, error)
+}
+
+var errNoCipher = errors.New("record is encrypted but no cipher is configured")
+
+// Marshal encodes a record as it is written to a store, the record is
+// sealed in an envelope that carries only its offset when c is set
+func Marshal(c Cipher, record *api.Record) ([]byte, error) {
+	p, err := proto.Marshal(record)
+	if err != nil || c == nil {
+		return p, err
+	}
+	return seal(c, record.Offset, NoCompression, p)
+}
+
+// seal encrypts a marshalled record compressed with the codec
+// into an envelope carrying its offset
+func seal(c Cipher, offset uint64, codec Codec, p []byte) ([]byte, error) {
+	keyID, sealed, err := c.Seal(p)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&api.Record{
+		Offset: offset,
+		KeyId:  keyID,
+		Sealed: sealed,
+		Codec:  uint32(codec),
+	})
+}
+
+// Unmarshal decodes a record read from a store, opening it with c
+// if it is sealed. Records written without a cipher are read as is
+func Unmarshal(c Cipher, p []byte) (*api.Record, error) {
+	record := &api.Record{}
+	if err := proto.Unmarshal(p, record); err != nil {
+		return nil, err
+	}
+	if len(record.Sealed) == 0 {
+		return record, nil
+	}
+	if c == nil {
+		return nil, errNoCipher
+	}
+	p, err := c.Open(record.KeyId, record.Sealed)
+	if err != nil {
+		return nil, err
+	}
+	if p, err = Codec(record.Codec).decompress(p); err != nil {
+		return nil, err
+	}
+	record = &api.Record{}
+	return record, proto.Unmarshal(p, record)
+}
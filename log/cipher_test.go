@@ -0,0 +1,73 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cipher-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// records written before encryption is enabled stay readable
+	plain, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	_, err = plain.Append(&api.Record{Id: "plain", Value: []byte("plaintext value")})
+	require.NoError(t, err)
+	require.NoError(t, plain.Close())
+
+	c := Config{Cipher: xorCipher(42)}
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	off, err := log.Append(&api.Record{Id: "secret", Value: []byte("sealed value")})
+	require.NoError(t, err)
+
+	read, err := log.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, []byte("sealed value"), read.Value)
+	require.Equal(t, off, read.Offset)
+	read, err = log.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("plaintext value"), read.Value)
+
+	b, err := ioutil.ReadAll(log.Reader())
+	require.NoError(t, err)
+	require.True(t, bytes.Contains(b, []byte("plaintext value")))
+	require.False(t, bytes.Contains(b, []byte("sealed value")))
+	require.NoError(t, log.Close())
+
+	// sealed records can't be read without the cipher
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	_, err = log.Read(off)
+	require.True(t, errors.Is(err, errNoCipher))
+}
+
+// xorCipher is a stand in for a real cipher
+type xorCipher byte
+
+func (c xorCipher) Seal(plaintext []byte) (uint32, []byte, error) {
+	return uint32(c), c.xor(plaintext), nil
+}
+
+func (c xorCipher) Open(keyID uint32, ciphertext []byte) ([]byte, error) {
+	if keyID != uint32(c) {
+		return nil, errors.New("unknown key")
+	}
+	return c.xor(ciphertext), nil
+}
+
+func (c xorCipher) xor(p []byte) []byte {
+	out := make([]byte, len(p))
+	for i := range p {
+		out[i] = p[i] ^ byte(c)
+	}
+	return out
+}
@@ -0,0 +1,130 @@
+package log
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/michael-diggin/yass/api"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec compresses the records written to a segment
+type Codec byte
+
+const (
+	NoCompression Codec = iota
+	// Flate compresses each record with DEFLATE at the fastest level,
+	// it suits small records with repetitive values such as JSON
+	Flate
+)
+
+func (c Codec) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Flate:
+		return "flate"
+	}
+	return fmt.Sprintf("codec(%d)", byte(c))
+}
+
+// flate's compressors and decompressors are large, so they're
+// reused across records rather than allocated for each
+var (
+	flateWriters = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	}}
+	flateReaders = sync.Pool{New: func() interface{} {
+		return flate.NewReader(nil)
+	}}
+)
+
+func (c Codec) compress(p []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return p, nil
+	case Flate:
+		var buf bytes.Buffer
+		w := flateWriters.Get().(*flate.Writer)
+		defer flateWriters.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(p); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown codec %d", byte(c))
+}
+
+func (c Codec) decompress(p []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return p, nil
+	case Flate:
+		r := flateReaders.Get().(io.ReadCloser)
+		defer flateReaders.Put(r)
+		if err := r.(flate.Resetter).Reset(bytes.NewReader(p), nil); err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	}
+	return nil, fmt.Errorf("unknown codec %d", byte(c))
+}
+
+// Each record in a compressed segment starts with a byte saying how
+// it's compressed. Ciphertext doesn't compress, so sealed records are
+// compressed before they're sealed and stored as marshalled
+const (
+	compressedRecord byte = iota
+	sealedRecord
+)
+
+var errRecordKind = errors.New("unknown kind of compressed record")
+
+// pack encodes a record as it's stored in a segment with the codec
+func (c Codec) pack(cipher Cipher, record *api.Record) ([]byte, error) {
+	if c == NoCompression {
+		return Marshal(cipher, record)
+	}
+	p, err := proto.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if p, err = c.compress(p); err != nil {
+		return nil, err
+	}
+	if cipher == nil {
+		return append([]byte{compressedRecord}, p...), nil
+	}
+	if p, err = seal(cipher, record.Offset, c, p); err != nil {
+		return nil, err
+	}
+	return append([]byte{sealedRecord}, p...), nil
+}
+
+// unpack returns a record stored in a segment with the codec
+// as it's marshalled by Marshal
+func (c Codec) unpack(p []byte) ([]byte, error) {
+	if c == NoCompression {
+		return p, nil
+	}
+	if len(p) == 0 {
+		return nil, errRecordKind
+	}
+	switch p[0] {
+	case compressedRecord:
+		return c.decompress(p[1:])
+	case sealedRecord:
+		return p[1:], nil
+	}
+	return nil, errRecordKind
+}
@@ -0,0 +1,125 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCompressedLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codec-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	value := bytes.Repeat([]byte(`{"name":"yass","tags":["a","b"]}`), 32)
+	c := Config{}
+	c.Segment.MaxStoreBytes = 4096
+
+	// segments written before compression is enabled stay readable
+	plain, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, err = plain.Append(&api.Record{Id: "plain", Value: value})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, plain.Stats().Segments)
+	want, err := ioutil.ReadAll(plain.Reader())
+	require.NoError(t, err)
+	require.NoError(t, plain.Close())
+
+	c.Compression = Flate
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, err = log.Append(&api.Record{Id: "plain", Value: value})
+		require.NoError(t, err)
+	}
+	// the empty active segment is compressed once it's reopened
+	require.Equal(t, 2, log.Stats().Segments)
+	compressed := log.segments[1]
+	require.Equal(t, Flate, compressed.store.codec)
+	require.Less(t, compressed.store.Size(), uint64(len(value)))
+
+	for off := uint64(0); off < 8; off++ {
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		require.Equal(t, value, read.Value)
+		require.Equal(t, off, read.Offset)
+	}
+
+	// the reader reads the same records whichever codec they're
+	// stored with, so snapshots taken from it can be restored
+	b, err := ioutil.ReadAll(log.Reader())
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(b, want))
+	var offsets []uint64
+	for len(b) > 0 {
+		n := enc.Uint64(b)
+		record, err := Unmarshal(nil, b[lenWidth:lenWidth+n])
+		require.NoError(t, err)
+		offsets = append(offsets, record.Offset)
+		b = b[lenWidth+n:]
+	}
+	require.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6, 7}, offsets)
+	require.NoError(t, log.Close())
+
+	// the codec comes from the segment, not the config
+	log, err = NewLog(dir, Config{})
+	require.NoError(t, err)
+	read, err := log.Read(7)
+	require.NoError(t, err)
+	require.Equal(t, value, read.Value)
+	require.NoError(t, log.Close())
+
+	segments, err := OpenSegments(dir)
+	require.NoError(t, err)
+	for _, s := range segments {
+		require.Empty(t, s.Verify())
+	}
+	require.Equal(t, NoCompression, segments[0].Codec)
+	require.Equal(t, Flate, segments[1].Codec)
+}
+
+func TestCompressedEncryptedLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codec-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	value := bytes.Repeat([]byte(`{"name":"yass","tags":["a","b"]}`), 32)
+	c := Config{Cipher: xorCipher(42), Compression: Flate}
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	for i := 0; i < 2; i++ {
+		_, err = log.Append(&api.Record{Id: "secret", Value: value})
+		require.NoError(t, err)
+	}
+	// records are compressed before they're sealed
+	require.Less(t, log.segments[0].store.Size(), uint64(len(value)))
+	read, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, value, read.Value)
+
+	// snapshots carry the sealed records with their codec
+	b, err := ioutil.ReadAll(log.Reader())
+	require.NoError(t, err)
+	var offsets []uint64
+	for len(b) > 0 {
+		n := enc.Uint64(b)
+		envelope := &api.Record{}
+		require.NoError(t, proto.Unmarshal(b[lenWidth:lenWidth+n], envelope))
+		require.Equal(t, uint32(Flate), envelope.Codec)
+		require.NotEmpty(t, envelope.Sealed)
+		record, err := Unmarshal(c.Cipher, b[lenWidth:lenWidth+n])
+		require.NoError(t, err)
+		require.Equal(t, value, record.Value)
+		offsets = append(offsets, record.Offset)
+		b = b[lenWidth+n:]
+	}
+	require.Equal(t, []uint64{0, 1}, offsets)
+}
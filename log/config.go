@@ -1,9 +1,33 @@
 package log
 
+import "time"
+
 type Config struct {
 	Segment struct {
 		MaxStoreBytes uint64
+		// MaxIndexBytes caps the size of a segment's index, the index
+		// grows as records are appended and isn't capped when it's 0
 		MaxIndexBytes uint64
+		// MaxAge rolls over to a new segment once the active one is
+		// this old, segments only roll over by size when it's 0
+		MaxAge        time.Duration
 		InitialOffset uint64
 	}
+	Retention Retention
+	// Compression compresses the records of new segments, a segment
+	// keeps the codec it was created with. Records are compressed
+	// before they're sealed when the log is encrypted
+	Compression Codec
+	// Cipher encrypts records at rest, they are stored
+	// in plaintext when it is nil
+	Cipher Cipher
+}
+
+// Retention bounds how much of a log is kept. The oldest segments are
+// dropped while the log holds more than MaxBytes, or while they were
+// last written longer than MaxAge ago, the active segment is always
+// kept. There's no bound when they're 0
+type Retention struct {
+	MaxBytes uint64
+	MaxAge   time.Duration
 }
@@ -13,35 +13,60 @@ var (
 	entWidth        = offWidth + posWidth
 )
 
+// indexGrowBytes is the size of the chunks an index's
+// file and mapping grow by as entries are written
+var indexGrowBytes = 1024 * entWidth
+
 type index struct {
 	file *os.File
 	mmap gommap.MMap
 	size uint64
+	// maxBytes caps the index's size, there's no cap when it's 0
+	maxBytes uint64
 }
 
 func newIndex(f *os.File, c Config) (*index, error) {
 	idx := &index{
-		file: f,
+		file:     f,
+		maxBytes: c.Segment.MaxIndexBytes,
 	}
 	fi, err := os.Stat(f.Name())
 	if err != nil {
 		return nil, err
 	}
 	idx.size = uint64(fi.Size())
-	if err := os.Truncate(
-		f.Name(), int64(c.Segment.MaxIndexBytes),
-	); err != nil {
+	if err := idx.remap(idx.size); err != nil {
 		return nil, err
 	}
+	return idx, nil
+}
 
-	if idx.mmap, err = gommap.Map(
-		idx.file.Fd(),
+// remap grows the file to hold at least `size` bytes, rounded up to
+// a whole chunk, and maps all of it
+func (i *index) remap(size uint64) error {
+	size = (size/indexGrowBytes + 1) * indexGrowBytes
+	if i.mmap != nil {
+		if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
+			return err
+		}
+		if err := i.mmap.UnsafeUnmap(); err != nil {
+			return err
+		}
+		i.mmap = nil
+	}
+	if err := i.file.Truncate(int64(size)); err != nil {
+		return err
+	}
+	mmap, err := gommap.Map(
+		i.file.Fd(),
 		gommap.PROT_READ|gommap.PROT_WRITE,
 		gommap.MAP_SHARED,
-	); err != nil {
-		return nil, err
+	)
+	if err != nil {
+		return err
 	}
-	return idx, nil
+	i.mmap = mmap
+	return nil
 }
 
 // Read takes in an offset and returns the associated position
@@ -65,9 +90,14 @@ func (i *index) Read(in int64) (out uint32, pos uint64, err error) {
 
 // Write appends the given offset and position to the index
 func (i *index) Write(off uint32, pos uint64) error {
-	if uint64(len(i.mmap)) < i.size+entWidth {
+	if i.maxBytes > 0 && i.size+entWidth > i.maxBytes {
 		return io.EOF
 	}
+	if uint64(len(i.mmap)) < i.size+entWidth {
+		if err := i.remap(i.size + entWidth); err != nil {
+			return err
+		}
+	}
 	enc.PutUint32(i.mmap[i.size:i.size+offWidth], off)
 	enc.PutUint64(i.mmap[i.size+offWidth:i.size+entWidth], pos)
 	i.size += uint64(entWidth)
@@ -84,6 +114,9 @@ func (i *index) Close() error {
 	if err := i.mmap.Sync(gommap.MS_SYNC); err != nil {
 		return err
 	}
+	if err := i.mmap.UnsafeUnmap(); err != nil {
+		return err
+	}
 	if err := i.file.Sync(); err != nil {
 		return err
 	}
@@ -52,3 +52,36 @@ func TestIndex(t *testing.T) {
 	require.Equal(t, uint32(1), off)
 	require.Equal(t, entries[1].Pos, pos)
 }
+
+func TestIndexGrows(t *testing.T) {
+	f, err := ioutil.TempFile(os.TempDir(), "index_test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	idx, err := newIndex(f, Config{})
+	require.NoError(t, err)
+	require.Equal(t, int(indexGrowBytes), len(idx.mmap))
+
+	n := uint32(3 * indexGrowBytes / entWidth)
+	for off := uint32(0); off < n; off++ {
+		require.NoError(t, idx.Write(off, uint64(off)*10))
+	}
+	require.Equal(t, int(3*indexGrowBytes), len(idx.mmap))
+	_, pos, err := idx.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), pos)
+	require.NoError(t, idx.Close())
+
+	fi, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, int64(n)*int64(entWidth), fi.Size())
+
+	f, _ = os.OpenFile(f.Name(), os.O_RDWR, 0600)
+	idx, err = newIndex(f, Config{})
+	require.NoError(t, err)
+	defer idx.Close()
+	off, pos, err := idx.Read(-1)
+	require.NoError(t, err)
+	require.Equal(t, n-1, off)
+	require.Equal(t, uint64(n-1)*10, pos)
+}
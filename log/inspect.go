@@ -0,0 +1,346 @@
+package log
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/michael-diggin/yass/api"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// SegmentFile is a segment's store and index as they are on disk,
+// read without opening them for writing
+type SegmentFile struct {
+	Dir        string
+	BaseOffset uint64
+	StoreBytes uint64
+	IndexBytes uint64
+	Codec      Codec
+	// Index holds the store positions of the entries in the index,
+	// Store the positions of the complete records read from the store
+	Index []uint64
+	Store []uint64
+	// ValidBytes is where the last complete record in the store ends,
+	// the bytes after it are a corrupt or partly written tail
+	ValidBytes uint64
+	// corrupt is why the record at ValidBytes couldn't be read, it's
+	// nil when the tail is just a partly written record
+	corrupt error
+}
+
+// OpenSegments reads the segments in dir, ordered by base offset
+func OpenSegments(dir string) ([]*SegmentFile, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []*SegmentFile
+	for _, file := range files {
+		ext := path.Ext(file.Name())
+		if ext != ".store" && ext != ".index" {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		// a segment is listed once, even when only one of its files exists
+		if ext == ".index" {
+			if _, err := os.Stat(path.Join(dir, fmt.Sprintf("%d.store", base))); err == nil {
+				continue
+			}
+		}
+		s, err := OpenSegment(dir, base)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, s)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].BaseOffset < segments[j].BaseOffset
+	})
+	return segments, nil
+}
+
+// OpenSegment reads the segment in dir with the given base offset
+func OpenSegment(dir string, base uint64) (*SegmentFile, error) {
+	s := &SegmentFile{Dir: dir, BaseOffset: base}
+	return s, s.read()
+}
+
+func (s *SegmentFile) storeName() string {
+	return path.Join(s.Dir, fmt.Sprintf("%d.store", s.BaseOffset))
+}
+
+func (s *SegmentFile) indexName() string {
+	return path.Join(s.Dir, fmt.Sprintf("%d.index", s.BaseOffset))
+}
+
+func (s *SegmentFile) read() error {
+	if err := s.readIndex(); err != nil {
+		return err
+	}
+	return s.readStore()
+}
+
+// readIndex reads the index's entries up to the first that's out
+// of sequence. An index left open by a crash is padded with zeros
+// to its max size, so its entries end there
+func (s *SegmentFile) readIndex() error {
+	b, err := ioutil.ReadFile(s.indexName())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.IndexBytes = uint64(len(b))
+	for i := uint64(0); (i+1)*entWidth <= s.IndexBytes; i++ {
+		ent := b[i*entWidth:]
+		off, pos := enc.Uint32(ent[:offWidth]), enc.Uint64(ent[offWidth:entWidth])
+		if uint64(off) != i || (i > 0 && pos <= s.Index[i-1]) {
+			break
+		}
+		s.Index = append(s.Index, pos)
+	}
+	return nil
+}
+
+// readStore reads the store's records in order, stopping at the first
+// that's incomplete, can't be decoded or doesn't have the next offset
+func (s *SegmentFile) readStore() error {
+	f, err := os.Open(s.storeName())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	s.StoreBytes = uint64(fi.Size())
+	header, err := readHeader(f, s.StoreBytes)
+	if err != nil {
+		return err
+	}
+	s.Codec, s.ValidBytes, _ = parseHeader(header)
+
+	r := bufio.NewReader(io.NewSectionReader(f, int64(s.ValidBytes), int64(s.StoreBytes-s.ValidBytes)))
+	size := make([]byte, lenWidth)
+	for {
+		if _, err := io.ReadFull(r, size); err != nil {
+			break
+		}
+		n := enc.Uint64(size)
+		if n > s.StoreBytes-s.ValidBytes-lenWidth {
+			break
+		}
+		// no record is empty, so a zero length is a zero-filled tail
+		// when only zeros follow the last record, and corrupt otherwise
+		if n == 0 {
+			zeros, err := zeroFrom(f, s.ValidBytes, s.StoreBytes)
+			if err != nil {
+				return err
+			}
+			if !zeros {
+				s.corrupt = fmt.Errorf("record has a zero length")
+			}
+			break
+		}
+		p := make([]byte, n)
+		if _, err := io.ReadFull(r, p); err != nil {
+			return err
+		}
+		if off, err := s.decode(p); err != nil {
+			s.corrupt = err
+		} else if want := s.NextOffset(); off != want {
+			s.corrupt = fmt.Errorf("record has offset %d, expected %d", off, want)
+		}
+		// the length may have been written ahead of the record
+		if s.corrupt != nil {
+			zeros, err := zeroFrom(f, s.ValidBytes+lenWidth, s.StoreBytes)
+			if err != nil {
+				return err
+			}
+			if zeros {
+				s.corrupt = nil
+			}
+			break
+		}
+		s.Store = append(s.Store, s.ValidBytes)
+		s.ValidBytes += lenWidth + n
+	}
+	return nil
+}
+
+// decode returns the offset of a record read from the store. Sealed
+// records are checked by their envelope, which carries the offset
+// without needing the cipher
+func (s *SegmentFile) decode(p []byte) (uint64, error) {
+	p, err := s.Codec.unpack(p)
+	if err != nil {
+		return 0, err
+	}
+	record := &api.Record{}
+	if err := proto.Unmarshal(p, record); err != nil {
+		return 0, err
+	}
+	return record.Offset, nil
+}
+
+// zeroFrom reports whether the file holds only zeros from pos to end
+func zeroFrom(f *os.File, pos, end uint64) (bool, error) {
+	r := bufio.NewReader(io.NewSectionReader(f, int64(pos), int64(end-pos)))
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if b != 0 {
+			return false, nil
+		}
+	}
+}
+
+// NextOffset returns the offset after the last record in the store
+func (s *SegmentFile) NextOffset() uint64 {
+	return s.BaseOffset + uint64(len(s.Store))
+}
+
+// Read returns the record at an offset. Records are found by reading
+// the store, so they can be read when the index is damaged
+func (s *SegmentFile) Read(off uint64, c Cipher) (*api.Record, error) {
+	if off < s.BaseOffset || off >= s.NextOffset() {
+		return nil, api.ErrOffsetOutOfRange{Offset: off}
+	}
+	f, err := os.Open(s.storeName())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := readRecord(f, s.Store[off-s.BaseOffset])
+	if err != nil {
+		return nil, err
+	}
+	if b, err = s.Codec.unpack(b); err != nil {
+		return nil, err
+	}
+	return Unmarshal(c, b)
+}
+
+// Verify returns the inconsistencies between the segment's index and
+// store, it's consistent when there are none
+func (s *SegmentFile) Verify() []string {
+	var problems []string
+	for i, pos := range s.Index {
+		off := s.BaseOffset + uint64(i)
+		if i >= len(s.Store) {
+			problems = append(problems, fmt.Sprintf("offset %d: indexed at position %d but not in the store", off, pos))
+			continue
+		}
+		if pos != s.Store[i] {
+			problems = append(problems, fmt.Sprintf("offset %d: indexed at position %d but stored at %d", off, pos, s.Store[i]))
+		}
+	}
+	if len(s.Store) > len(s.Index) {
+		problems = append(problems, fmt.Sprintf("offsets %d to %d: stored but not indexed",
+			s.BaseOffset+uint64(len(s.Index)), s.NextOffset()-1))
+	}
+	if extra := s.IndexBytes - uint64(len(s.Index))*entWidth; extra > 0 {
+		problems = append(problems, fmt.Sprintf("index: %d bytes after its last entry", extra))
+	}
+	if s.StoreBytes > s.ValidBytes {
+		problems = append(problems, fmt.Sprintf("store: %d corrupt bytes after position %d",
+			s.StoreBytes-s.ValidBytes, s.ValidBytes))
+	}
+	return problems
+}
+
+// RebuildIndex replaces the index with one holding the positions
+// of the records in the store
+func (s *SegmentFile) RebuildIndex() error {
+	b := make([]byte, uint64(len(s.Store))*entWidth)
+	for i, pos := range s.Store {
+		ent := b[uint64(i)*entWidth:]
+		enc.PutUint32(ent[:offWidth], uint32(i))
+		enc.PutUint64(ent[offWidth:entWidth], pos)
+	}
+	f, err := os.OpenFile(s.indexName(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s.Index, s.IndexBytes = append([]uint64(nil), s.Store...), uint64(len(b))
+	return nil
+}
+
+// TruncateTail discards the store's bytes after its last complete
+// record and rebuilds the index to match
+func (s *SegmentFile) TruncateTail() error {
+	if err := os.Truncate(s.storeName(), int64(s.ValidBytes)); err != nil {
+		return err
+	}
+	s.StoreBytes = s.ValidBytes
+	return s.RebuildIndex()
+}
+
+// indexed returns whether the index holds exactly the records in the store
+func (s *SegmentFile) indexed() bool {
+	if s.IndexBytes != uint64(len(s.Store))*entWidth || len(s.Index) != len(s.Store) {
+		return false
+	}
+	for i, pos := range s.Index {
+		if pos != s.Store[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// repairSegment discards a partly written tail and rebuilds a damaged
+// index before the segment's opened, corrupt records are left for yass-logtool
+func repairSegment(dir string, base uint64) error {
+	s, err := OpenSegment(dir, base)
+	if err != nil {
+		return err
+	}
+	if s.corrupt != nil {
+		return fmt.Errorf("segment %d: corrupt record at position %d: %v", base, s.ValidBytes, s.corrupt)
+	}
+	if s.StoreBytes > s.ValidBytes {
+		zap.L().Named("log").Warn("discarding a partly written record",
+			zap.Uint64("segment", base),
+			zap.Uint64("position", s.ValidBytes),
+			zap.Uint64("bytes", s.StoreBytes-s.ValidBytes),
+		)
+		return s.TruncateTail()
+	}
+	if !s.indexed() {
+		return s.RebuildIndex()
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package log
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspect-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 50
+	l, err := NewLog(dir, c)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := l.Append(&api.Record{Id: "key", Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	segments, err := OpenSegments(dir)
+	require.NoError(t, err)
+	require.Len(t, segments, 3)
+	for _, s := range segments {
+		require.Empty(t, s.Verify())
+	}
+	last := segments[len(segments)-1]
+	require.Equal(t, uint64(4), last.BaseOffset)
+	require.Equal(t, uint64(5), last.NextOffset())
+	record, err := segments[1].Read(2, nil)
+	require.NoError(t, err)
+	require.Equal(t, "key", record.Id)
+
+	// a crash leaves the index padded and a partly written record
+	indexFile := path.Join(dir, "4.index")
+	require.NoError(t, os.Truncate(indexFile, 1024))
+	f, err := os.OpenFile(path.Join(dir, "4.store"), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 20, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	last, err = OpenSegment(dir, 4)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"index: 1012 bytes after its last entry",
+		fmt.Sprintf("store: 10 corrupt bytes after position %d", last.ValidBytes),
+	}, last.Verify())
+
+	// a missing index is rebuilt from the store
+	require.NoError(t, os.Remove(path.Join(dir, "2.index")))
+	s, err := OpenSegment(dir, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"offsets 2 to 3: stored but not indexed"}, s.Verify())
+	require.NoError(t, s.RebuildIndex())
+	require.Empty(t, s.Verify())
+
+	require.NoError(t, last.TruncateTail())
+	last, err = OpenSegment(dir, 4)
+	require.NoError(t, err)
+	require.Empty(t, last.Verify())
+
+	l, err = NewLog(dir, c)
+	require.NoError(t, err)
+	defer l.Close()
+	for off := uint64(0); off < 5; off++ {
+		_, err := l.Read(off)
+		require.NoError(t, err)
+	}
+	off, err := l.Append(&api.Record{Id: "key", Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), off)
+}
+
+func TestRepairZeroFilledTail(t *testing.T) {
+	// a crash can leave the store's tail filled with zeros, maybe
+	// after a record's length, which isn't a record at the next offset
+	tails := [][]byte{
+		make([]byte, 64),
+		append([]byte{0, 0, 0, 0, 0, 0, 0, 16}, make([]byte, 16)...),
+	}
+	for _, records := range []int{2, 0} {
+		for i, tail := range tails {
+			dir, err := ioutil.TempDir("", "inspect-test")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			l, err := NewLog(dir, Config{})
+			require.NoError(t, err)
+			for j := 0; j < records; j++ {
+				_, err := l.Append(&api.Record{Id: "key", Value: []byte("hello world")})
+				require.NoError(t, err)
+			}
+			require.NoError(t, l.Close())
+
+			f, err := os.OpenFile(path.Join(dir, "0.store"), os.O_WRONLY|os.O_APPEND, 0644)
+			require.NoError(t, err)
+			_, err = f.Write(tail)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			s, err := OpenSegment(dir, 0)
+			require.NoError(t, err)
+			require.Nil(t, s.corrupt, "%d records, tail %d", records, i)
+			require.Equal(t, uint64(records), s.NextOffset(), "%d records, tail %d", records, i)
+
+			l, err = NewLog(dir, Config{})
+			require.NoError(t, err)
+			off, err := l.Append(&api.Record{Id: "key", Value: []byte("hello world")})
+			require.NoError(t, err)
+			require.Equal(t, uint64(records), off)
+			require.NoError(t, l.Close())
+		}
+	}
+}
+
+func TestZeroLengthBeforeRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspect-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err := l.Append(&api.Record{Id: "key", Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	// a zero length left ahead of the second record is
+	// corrupt rather than a zero-filled tail
+	name := path.Join(dir, "0.store")
+	b, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	first := lenWidth + enc.Uint64(b)
+	b = append(b[:first], append(make([]byte, lenWidth), b[first:]...)...)
+	require.NoError(t, ioutil.WriteFile(name, b, 0644))
+
+	s, err := OpenSegment(dir, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), s.NextOffset())
+	require.NotNil(t, s.corrupt)
+	require.NotEmpty(t, s.Verify())
+	require.Error(t, repairSegment(dir, 0))
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(b)), fi.Size())
+}
@@ -1,6 +1,7 @@
 package log
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"os"
@@ -9,8 +10,12 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/michael-diggin/yass/api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 type Log struct {
@@ -19,6 +24,10 @@ type Log struct {
 	Config        Config
 	activeSegment *segment
 	segments      []*segment
+	// readers counts the open Readers, segments truncated while
+	// there are any are kept in dropped until the last is closed
+	readers int
+	dropped []*segment
 }
 
 // NewLog returns a new Log instance
@@ -26,9 +35,6 @@ func NewLog(dir string, c Config) (*Log, error) {
 	if c.Segment.MaxStoreBytes == 0 {
 		c.Segment.MaxStoreBytes = 1024
 	}
-	if c.Segment.MaxIndexBytes == 0 {
-		c.Segment.MaxIndexBytes = 1024
-	}
 	l := &Log{Dir: dir, Config: c}
 	return l, l.setup()
 }
@@ -74,13 +80,32 @@ func (l *Log) newSegment(off uint64) error {
 
 // Append adds a record to the Log
 func (l *Log) Append(record *api.Record) (uint64, error) {
+	return l.AppendContext(context.Background(), record)
+}
+
+// AppendContext adds a record to the Log, tracing the write
+// as part of the operation in `ctx`
+func (l *Log) AppendContext(ctx context.Context, record *api.Record) (uint64, error) {
+	_, span := otel.Tracer("github.com/michael-diggin/yass/log").Start(ctx, "log.Append")
+	defer span.End()
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	// the active segment can be maxed by its age, or by its size
+	// when it was reopened, before anything is appended to it
+	if l.activeSegment.IsMaxed() {
+		if err := l.newSegment(l.activeSegment.nextOffset); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return 0, err
+		}
+	}
 	off, err := l.activeSegment.Append(record)
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return 0, err
 	}
+	span.SetAttributes(attribute.Int64("log.offset", int64(off)))
 	if l.activeSegment.IsMaxed() {
 		err = l.newSegment(off + 1)
 	}
@@ -115,7 +140,7 @@ func (l *Log) Close() error {
 			return err
 		}
 	}
-	return nil
+	return l.removeDropped()
 }
 
 // Remove will close the Log and remove any files
@@ -131,9 +156,31 @@ func (l *Log) Reset() error {
 	if err := l.Remove(); err != nil {
 		return err
 	}
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	l.segments = nil
 	return l.setup()
 }
 
+// Stats describes the on disk size of the Log
+type Stats struct {
+	Segments int
+	Bytes    uint64
+}
+
+// Stats returns the number of segments and the bytes held in their stores
+func (l *Log) Stats() Stats {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	stats := Stats{Segments: len(l.segments)}
+	for _, segment := range l.segments {
+		stats.Bytes += segment.store.Size()
+	}
+	return stats
+}
+
 // LowestOffset will return the lowest offset of the Log
 func (l *Log) LowestOffset() (uint64, error) {
 	l.mu.RLock()
@@ -155,45 +202,175 @@ func (l *Log) HighestOffset() (uint64, error) {
 }
 
 // Truncate removes all segments from the log whose highest offset is lower
-// than `lowest`
+// than `lowest`, other than the active segment
 func (l *Log) Truncate(lowest uint64) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	segments := make([]*segment, 0, len(l.segments))
 	for _, s := range l.segments {
-		if s.nextOffset <= lowest+1 {
-			if err := s.Remove(); err != nil {
-				return err
-			}
+		if s.nextOffset <= lowest+1 && s != l.activeSegment {
+			l.dropped = append(l.dropped, s)
 			continue
 		}
 		segments = append(segments, s)
 	}
 	l.segments = segments
+	if l.readers > 0 {
+		return nil
+	}
+	return l.removeDropped()
+}
+
+func (l *Log) removeDropped() error {
+	for len(l.dropped) > 0 {
+		if err := l.dropped[0].Remove(); err != nil {
+			return err
+		}
+		l.dropped = l.dropped[1:]
+	}
 	return nil
 }
 
-// Reader returns an io.Reader to read the entire Log
-func (l *Log) Reader() io.Reader {
+// RetainedOffset returns the lowest offset the log keeps under its
+// Retention, the segments before it can be dropped with Truncate
+func (l *Log) RetainedOffset() uint64 {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
+	c := l.Config.Retention
+	var total uint64
+	for _, s := range l.segments {
+		total += s.store.Size()
+	}
+	for _, s := range l.segments[:len(l.segments)-1] {
+		overSize := c.MaxBytes > 0 && total > c.MaxBytes
+		tooOld := c.MaxAge > 0 && time.Since(s.written) >= c.MaxAge
+		if !overSize && !tooOld {
+			return s.baseOffset
+		}
+		total -= s.store.Size()
+	}
+	return l.activeSegment.baseOffset
+}
+
+// TruncateFrom removes all records with an offset of `off` or higher.
+// The next record appended to the log is given offset `off`, an `off`
+// before the first segment empties the log and restarts it there
+func (l *Log) TruncateFrom(off uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if off < l.segments[0].baseOffset {
+		for _, s := range l.segments {
+			if err := s.Remove(); err != nil {
+				return err
+			}
+		}
+		l.segments = nil
+		return l.newSegment(off)
+	}
+	for i := len(l.segments) - 1; i >= 0; i-- {
+		s := l.segments[i]
+		if s.baseOffset >= off && i > 0 {
+			if err := s.Remove(); err != nil {
+				return err
+			}
+			l.segments = l.segments[:i]
+			continue
+		}
+		if off < s.nextOffset {
+			if err := s.TruncateFrom(off); err != nil {
+				return err
+			}
+		}
+		break
+	}
+	l.activeSegment = l.segments[len(l.segments)-1]
+	return nil
+}
+
+// Reader returns an io.ReadCloser to read the entire Log decompressed,
+// each record a big endian uint64 length then the record as marshalled
+// by Marshal. Segments truncated while it's open are kept until it's closed
+func (l *Log) Reader() io.ReadCloser {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	readers := make([]io.Reader, len(l.segments))
 	for i, segment := range l.segments {
-		readers[i] = &originReader{segment.store, 0}
+		if segment.store.start == 0 {
+			readers[i] = &originReader{segment.store, 0}
+		} else {
+			readers[i] = &decompressReader{store: segment.store, pos: segment.store.start}
+		}
 	}
-	return io.MultiReader(readers...)
+	l.readers++
+	return &logReader{Reader: io.MultiReader(readers...), log: l}
+}
+
+// logReader releases the log's segments once it's closed
+type logReader struct {
+	io.Reader
+	log  *Log
+	once sync.Once
+}
+
+// Close implements the io.Closer interface
+func (r *logReader) Close() (err error) {
+	r.once.Do(func() {
+		l := r.log
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.readers--
+		if l.readers == 0 {
+			err = l.removeDropped()
+		}
+	})
+	return err
 }
 
+// originReader reads a store from the start. The store isn't embedded,
+// so the file's WriteTo isn't promoted for io.Copy to read past
 type originReader struct {
-	*store
-	off int64
+	store *store
+	off   int64
 }
 
 // Read implements the io.Reader interface
 func (o *originReader) Read(p []byte) (n int, err error) {
-	n, err = o.ReadAt(p, o.off)
+	n, err = o.store.ReadAt(p, o.off)
 	o.off += int64(n)
 	return n, err
 }
+
+// decompressReader reads a compressed store's records from its first,
+// unpacking each of them as it's marshalled by Marshal
+type decompressReader struct {
+	store *store
+	pos   uint64
+	buf   []byte
+}
+
+// Read implements the io.Reader interface
+func (d *decompressReader) Read(p []byte) (int, error) {
+	if len(d.buf) == 0 {
+		if d.pos >= d.store.Size() {
+			return 0, io.EOF
+		}
+		b, err := d.store.Read(d.pos)
+		if err != nil {
+			return 0, err
+		}
+		d.pos += lenWidth + uint64(len(b))
+		if b, err = d.store.codec.unpack(b); err != nil {
+			return 0, err
+		}
+		d.buf = make([]byte, lenWidth+len(b))
+		enc.PutUint64(d.buf, uint64(len(b)))
+		copy(d.buf[lenWidth:], b)
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
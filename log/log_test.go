@@ -3,7 +3,9 @@ package log
 import (
 	"io/ioutil"
 	"os"
+	"path"
 	"testing"
+	"time"
 
 	"github.com/michael-diggin/yass/api"
 	"github.com/stretchr/testify/require"
@@ -19,6 +21,8 @@ func TestLog(t *testing.T) {
 		"init with existing segments": testInitExisting,
 		"reader":                      testReader,
 		"truncate":                    testTruncate,
+		"truncate from":               testTruncateFrom,
+		"truncate from before start":  testTruncateFromBeforeStart,
 	} {
 		t.Run(scenario, func(t *testing.T) {
 			dir, err := ioutil.TempDir("", "store-test")
@@ -116,3 +120,127 @@ func testTruncate(t *testing.T, log *Log) {
 	_, err = log.Read(0)
 	require.Error(t, err)
 }
+
+func testTruncateFrom(t *testing.T, log *Log) {
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+
+	err := log.TruncateFrom(1)
+	require.NoError(t, err)
+	_, err = log.Read(1)
+	require.Error(t, err)
+	_, err = log.Read(0)
+	require.NoError(t, err)
+	off, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	off, err = log.Append(append)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	read, err := log.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, append.Value, read.Value)
+}
+
+func testTruncateFromBeforeStart(t *testing.T, log *Log) {
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Truncate(1))
+	off, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), off)
+
+	require.NoError(t, log.TruncateFrom(1))
+	_, err = log.Read(2)
+	require.Error(t, err)
+	off, err = log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+
+	off, err = log.Append(append)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	read, err := log.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, append.Value, read.Value)
+}
+
+func TestRetainedOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	c.Retention.MaxAge = time.Hour
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	append := &api.Record{Value: []byte("hello world, hello world")}
+	for i := 0; i < 4; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+	require.Len(t, log.segments, 5)
+	require.Equal(t, uint64(0), log.RetainedOffset())
+
+	log.segments[0].written = time.Now().Add(-2 * time.Hour)
+	log.segments[1].written = time.Now().Add(-2 * time.Hour)
+	require.Equal(t, uint64(2), log.RetainedOffset())
+
+	// the active segment is kept however much the log holds
+	log.Config.Retention.MaxBytes = 1
+	require.Equal(t, uint64(4), log.RetainedOffset())
+	require.NoError(t, log.Truncate(10))
+	require.Len(t, log.segments, 1)
+	off, err := log.Append(append)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), off)
+}
+
+func TestTruncateWhileReading(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c := Config{}
+	c.Segment.MaxStoreBytes = 32
+	log, err := NewLog(dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+
+	append := &api.Record{Value: []byte("hello world, hello world")}
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+	reader := log.Reader()
+	want, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	// the segments a reader was opened with are kept until it's closed
+	reader = log.Reader()
+	require.NoError(t, log.Truncate(1))
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), lowest)
+	b, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, want, b)
+	require.FileExists(t, path.Join(dir, "0.store"))
+
+	require.NoError(t, reader.Close())
+	_, err = os.Stat(path.Join(dir, "0.store"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"time"
 
 	"github.com/michael-diggin/yass/api"
-	"google.golang.org/protobuf/proto"
 )
 
 type segment struct {
@@ -15,6 +15,10 @@ type segment struct {
 	baseOffset uint64
 	nextOffset uint64
 	config     Config
+	// created is when the segment was created, or when its store was
+	// last written for an existing segment whose header doesn't say
+	created time.Time
+	written time.Time
 }
 
 func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
@@ -22,6 +26,9 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 		baseOffset: baseOffset,
 		config:     c,
 	}
+	if err := repairSegment(dir, baseOffset); err != nil {
+		return nil, err
+	}
 
 	storeFile, err := os.OpenFile(
 		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store")),
@@ -33,6 +40,17 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 	if s.store, err = newStore(storeFile); err != nil {
 		return nil, err
 	}
+	s.created = time.Now()
+	if s.store.size == 0 && (c.Compression != NoCompression || c.Segment.MaxAge > 0) {
+		if err := s.store.writeHeader(c.Compression, s.created); err != nil {
+			return nil, err
+		}
+	} else if !s.store.created.IsZero() {
+		s.created = s.store.created
+	} else if fi, err := storeFile.Stat(); err == nil && fi.Size() > 0 {
+		s.created = fi.ModTime()
+	}
+	s.written = s.created
 
 	indexFile, err := os.OpenFile(
 		path.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".index")),
@@ -57,7 +75,7 @@ func newSegment(dir string, baseOffset uint64, c Config) (*segment, error) {
 func (s *segment) Append(record *api.Record) (offset uint64, err error) {
 	cur := s.nextOffset
 	record.Offset = cur
-	p, err := proto.Marshal(record)
+	p, err := s.store.codec.pack(s.config.Cipher, record)
 	if err != nil {
 		return 0, err
 	}
@@ -73,6 +91,7 @@ func (s *segment) Append(record *api.Record) (offset uint64, err error) {
 		return 0, err
 	}
 	s.nextOffset++
+	s.written = time.Now()
 	return cur, nil
 }
 
@@ -86,15 +105,43 @@ func (s *segment) Read(off uint64) (*api.Record, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from store:%w", err)
 	}
-	record := &api.Record{}
-	err = proto.Unmarshal(p, record)
-	return record, err
+	if p, err = s.store.codec.unpack(p); err != nil {
+		return nil, err
+	}
+	return Unmarshal(s.config.Cipher, p)
 }
 
-// IsMaxed returns whether the segement has reached its max size
+// TruncateFrom removes the records from offset `off` onwards
+func (s *segment) TruncateFrom(off uint64) error {
+	if off < s.baseOffset {
+		off = s.baseOffset
+	}
+	rel := off - s.baseOffset
+	pos := s.store.size
+	if off < s.nextOffset {
+		_, p, err := s.index.Read(int64(rel))
+		if err != nil {
+			return fmt.Errorf("failed to read from index: %w", err)
+		}
+		pos = p
+	}
+	if err := s.store.Truncate(pos); err != nil {
+		return err
+	}
+	s.index.size = rel * entWidth
+	s.nextOffset = off
+	return nil
+}
+
+// IsMaxed returns whether the segement has reached its max size,
+// or its max age when it holds any records
 func (s *segment) IsMaxed() bool {
-	return s.store.size >= s.config.Segment.MaxStoreBytes ||
-		s.index.size >= s.config.Segment.MaxIndexBytes
+	c := s.config.Segment
+	if c.MaxAge > 0 && s.nextOffset > s.baseOffset && time.Since(s.created) >= c.MaxAge {
+		return true
+	}
+	return s.store.size >= c.MaxStoreBytes ||
+		(c.MaxIndexBytes > 0 && s.index.size >= c.MaxIndexBytes)
 }
 
 // Remove closes the segment and removes the store and index files
@@ -4,10 +4,13 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"testing"
+	"time"
 
 	"github.com/michael-diggin/yass/api"
 	"github.com/stretchr/testify/require"
+	"github.com/tysontate/gommap"
 )
 
 func TestSegment(t *testing.T) {
@@ -56,3 +59,88 @@ func TestSegment(t *testing.T) {
 	require.False(t, s.IsMaxed())
 
 }
+
+func TestSegmentRepairedOnStartUp(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "segment-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxIndexBytes = 1024
+
+	s, err := newSegment(dir, 16, c)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := s.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	// a crash after the store is written, but before the index is,
+	// leaves the index padded and without the last record
+	_, _, err = s.store.Append(mustMarshal(t, &api.Record{Offset: 19, Value: []byte("hello world")}))
+	require.NoError(t, err)
+	require.NoError(t, s.store.Close())
+	require.NoError(t, s.index.mmap.Sync(gommap.MS_SYNC))
+	require.NoError(t, s.index.file.Close())
+
+	s, err = newSegment(dir, 16, c)
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), s.nextOffset)
+	got, err := s.Read(19)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), got.Value)
+
+	// as does a partly written record, which is discarded
+	size := s.store.size
+	_, err = s.store.Write([]byte{0, 0, 0, 0, 0, 0, 1, 0, 1})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	s, err = newSegment(dir, 16, c)
+	require.NoError(t, err)
+	require.Equal(t, size, s.store.size)
+	off, err := s.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), off)
+
+	// a corrupt record is left for yass-logtool
+	_, err = s.store.Write([]byte{0, 0, 0, 0, 0, 0, 0, 1, 0xff})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	_, err = newSegment(dir, 16, c)
+	require.Error(t, err)
+}
+
+func mustMarshal(t *testing.T, record *api.Record) []byte {
+	t.Helper()
+	p, err := Marshal(nil, record)
+	require.NoError(t, err)
+	return p
+}
+
+func TestSegmentMaxAge(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "segment-test")
+	defer os.RemoveAll(dir)
+
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	c.Segment.MaxAge = time.Hour
+
+	s, err := newSegment(dir, 0, c)
+	require.NoError(t, err)
+	created := s.created
+	s.created = time.Now().Add(-2 * time.Hour)
+	// an empty segment isn't rolled over however old it is
+	require.False(t, s.IsMaxed())
+	_, err = s.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	require.True(t, s.IsMaxed())
+	require.NoError(t, s.Close())
+
+	// the creation time survives a reopen, whenever the store was last written
+	now := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(path.Join(dir, "0.store"), now, now))
+	s, err = newSegment(dir, 0, c)
+	require.NoError(t, err)
+	defer s.Close()
+	require.True(t, created.Equal(s.created))
+	require.Equal(t, uint64(1), s.nextOffset)
+}
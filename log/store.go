@@ -2,15 +2,34 @@ package log
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"io"
 	"os"
 	"sync"
+	"time"
 )
 
 var enc = binary.BigEndian
 
 const (
-	lenWidth = 8
+	lenWidth    = 8
+	headerWidth = 16
+)
+
+// A store starts with a header when its records are compressed or its
+// log rolls segments over by age, stores without one hold records as
+// they're marshalled:
+//
+//	magic   7 bytes, "YASSEG2"
+//	codec   1 byte
+//	created 8 bytes, unix nanoseconds the segment was created at
+//
+// Stores created before the header held the creation time start with
+// "YASSEG1" and the codec
+var (
+	storeMagic   = []byte("YASSEG2")
+	storeMagicV1 = []byte("YASSEG1")
 )
 
 type store struct {
@@ -18,6 +37,12 @@ type store struct {
 	mu   sync.Mutex
 	buf  *bufio.Writer
 	size uint64
+	// codec compresses the records, start is where the first is
+	codec Codec
+	start uint64
+	// created is when the store was created, it's zero when
+	// the store has no header or its header doesn't say
+	created time.Time
 }
 
 func newStore(f *os.File) (*store, error) {
@@ -26,11 +51,61 @@ func newStore(f *os.File) (*store, error) {
 		return nil, err
 	}
 	size := uint64(fi.Size())
-	return &store{
+	s := &store{
 		File: f,
 		size: size,
 		buf:  bufio.NewWriter(f),
-	}, nil
+	}
+	header, err := readHeader(f, size)
+	if err != nil {
+		return nil, err
+	}
+	s.codec, s.start, s.created = parseHeader(header)
+	return s, nil
+}
+
+// readHeader returns the bytes at the start of a store that can hold its header
+func readHeader(f *os.File, size uint64) ([]byte, error) {
+	header := make([]byte, headerWidth)
+	if size < headerWidth {
+		header = header[:size]
+	}
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// parseHeader returns the codec named in a store's header, where its
+// first record starts and when it was created. A store without a
+// header isn't compressed
+func parseHeader(header []byte) (Codec, uint64, time.Time) {
+	n := len(storeMagic)
+	switch {
+	case len(header) >= headerWidth && bytes.Equal(header[:n], storeMagic):
+		created := time.Unix(0, int64(enc.Uint64(header[n+1:headerWidth])))
+		return Codec(header[n]), headerWidth, created
+	case len(header) >= n+1 && bytes.Equal(header[:n], storeMagicV1):
+		return Codec(header[n]), uint64(n + 1), time.Time{}
+	}
+	return NoCompression, 0, time.Time{}
+}
+
+// writeHeader starts an empty store with a header naming its codec
+// and when it was created
+func (s *store) writeHeader(c Codec, created time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	header := make([]byte, headerWidth)
+	copy(header, storeMagic)
+	header[len(storeMagic)] = byte(c)
+	enc.PutUint64(header[len(storeMagic)+1:], uint64(created.UnixNano()))
+	if _, err := s.buf.Write(header); err != nil {
+		return err
+	}
+	s.size, s.codec, s.start, s.created = headerWidth, c, headerWidth, created
+	return nil
 }
 
 // Append persist the bytes `p` to the store
@@ -59,15 +134,7 @@ func (s *store) Read(pos uint64) ([]byte, error) {
 	if err := s.buf.Flush(); err != nil {
 		return nil, err
 	}
-	size := make([]byte, lenWidth)
-	if _, err := s.File.ReadAt(size, int64(pos)); err != nil {
-		return nil, err
-	}
-	b := make([]byte, enc.Uint64(size))
-	if _, err := s.File.ReadAt(b, int64(pos+lenWidth)); err != nil {
-		return nil, err
-	}
-	return b, nil
+	return readRecord(s.File, pos)
 }
 
 // ReadAt reads len(p) bytes beginning at `off`
@@ -81,6 +148,28 @@ func (s *store) ReadAt(p []byte, off int64) (int, error) {
 	return s.File.ReadAt(p, off)
 }
 
+// Truncate discards everything in the store from position `pos` onwards
+func (s *store) Truncate(pos uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if err := s.File.Truncate(int64(pos)); err != nil {
+		return err
+	}
+	s.size = pos
+	return nil
+}
+
+// Size returns the number of bytes written to the store
+func (s *store) Size() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
 // Close persists any buffered data before closing the file
 func (s *store) Close() error {
 	s.mu.Lock()
@@ -91,3 +180,16 @@ func (s *store) Close() error {
 	}
 	return s.File.Close()
 }
+
+// readRecord reads the length prefixed record at pos
+func readRecord(r io.ReaderAt, pos uint64) ([]byte, error) {
+	size := make([]byte, lenWidth)
+	if _, err := r.ReadAt(size, int64(pos)); err != nil {
+		return nil, err
+	}
+	b := make([]byte, enc.Uint64(size))
+	if _, err := r.ReadAt(b, int64(pos+lenWidth)); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	gometrics "github.com/armon/go-metrics"
+	gometricsprom "github.com/armon/go-metrics/prometheus"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/hashicorp/serf/serf"
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "yass"
+
+// Raft reports its metrics through go-metrics' process-global sink,
+// so only one Metrics can be installed in a process at a time
+var (
+	globalMu  sync.Mutex
+	installed bool
+)
+
+// ErrInstalled is returned by New while another Metrics is open
+var ErrInstalled = errors.New("metrics: another node's metrics are already installed in this process")
+
+// DB is the store whose state is exported
+type DB interface {
+	Len() int
+	LogStats() log.Stats
+	IsLeader() bool
+	ListNamespaces() []*api.NamespaceUsage
+}
+
+type Membership interface {
+	Members() []serf.Member
+}
+
+type Config struct {
+	DB         DB
+	Membership Membership
+}
+
+// Metrics holds the Prometheus registry for a node
+type Metrics struct {
+	Config
+	registry *prometheus.Registry
+	// GRPC records per-method latency and error counts,
+	// it should be installed on the gRPC server
+	GRPC *grpc_prometheus.ServerMetrics
+}
+
+// New registers the node's collectors, and sends the metrics
+// Raft reports through go-metrics to the same registry. It fails
+// with ErrInstalled until the previous Metrics is closed
+func New(config Config) (*Metrics, error) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	if installed {
+		return nil, ErrInstalled
+	}
+
+	m := &Metrics{
+		Config:   config,
+		registry: prometheus.NewRegistry(),
+		GRPC:     grpc_prometheus.NewServerMetrics(),
+	}
+	m.GRPC.EnableHandlingTimeHistogram()
+
+	collectors := []prometheus.Collector{
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.GRPC,
+		&collector{m},
+	}
+	for _, c := range collectors {
+		if err := m.registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	sink, err := gometricsprom.NewPrometheusSinkFrom(gometricsprom.PrometheusOpts{
+		Expiration: time.Minute,
+		Registerer: m.registry,
+	})
+	if err != nil {
+		return nil, err
+	}
+	conf := gometrics.DefaultConfig(namespace)
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := gometrics.NewGlobal(conf, sink); err != nil {
+		return nil, err
+	}
+	installed = true
+	return m, nil
+}
+
+// Close stops sending go-metrics to the registry so another
+// Metrics can be installed
+func (m *Metrics) Close() error {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	if _, err := gometrics.NewGlobal(gometrics.DefaultConfig(namespace), &gometrics.BlackholeSink{}); err != nil {
+		return err
+	}
+	installed = false
+	return nil
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+var (
+	keysDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "kv", "keys"),
+		"Number of keys in the local store.", nil, nil,
+	)
+	segmentsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "log", "segments"),
+		"Number of segments in the persistent log.", nil, nil,
+	)
+	bytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "log", "bytes"),
+		"Bytes held in the persistent log's store files.", nil, nil,
+	)
+	leaderDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "raft", "leader"),
+		"Whether this node is the Raft leader.", nil, nil,
+	)
+	namespaceKeysDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "namespace", "keys"),
+		"Number of keys in each namespace.", []string{"namespace"}, nil,
+	)
+	namespaceBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "namespace", "bytes"),
+		"Bytes taken by the keys and values in each namespace.", []string{"namespace"}, nil,
+	)
+	namespaceMaxKeysDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "namespace", "max_keys"),
+		"Key quota of each namespace, zero is unlimited.", []string{"namespace"}, nil,
+	)
+	namespaceMaxBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "namespace", "max_bytes"),
+		"Byte quota of each namespace, zero is unlimited.", []string{"namespace"}, nil,
+	)
+	membersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "serf", "members"),
+		"Number of serf members by status.", []string{"status"}, nil,
+	)
+)
+
+// collector reads the node's state each time it is scraped
+type collector struct {
+	*Metrics
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- keysDesc
+	ch <- segmentsDesc
+	ch <- bytesDesc
+	ch <- leaderDesc
+	ch <- namespaceKeysDesc
+	ch <- namespaceBytesDesc
+	ch <- namespaceMaxKeysDesc
+	ch <- namespaceMaxBytesDesc
+	ch <- membersDesc
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	if c.DB != nil {
+		stats := c.DB.LogStats()
+		leader := 0.0
+		if c.DB.IsLeader() {
+			leader = 1
+		}
+		ch <- prometheus.MustNewConstMetric(keysDesc, prometheus.GaugeValue, float64(c.DB.Len()))
+		ch <- prometheus.MustNewConstMetric(segmentsDesc, prometheus.GaugeValue, float64(stats.Segments))
+		ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.GaugeValue, float64(stats.Bytes))
+		ch <- prometheus.MustNewConstMetric(leaderDesc, prometheus.GaugeValue, leader)
+		for _, ns := range c.DB.ListNamespaces() {
+			name := ns.Namespace.Name
+			ch <- prometheus.MustNewConstMetric(namespaceKeysDesc, prometheus.GaugeValue, float64(ns.Keys), name)
+			ch <- prometheus.MustNewConstMetric(namespaceBytesDesc, prometheus.GaugeValue, float64(ns.Bytes), name)
+			ch <- prometheus.MustNewConstMetric(namespaceMaxKeysDesc, prometheus.GaugeValue, float64(ns.Namespace.MaxKeys), name)
+			ch <- prometheus.MustNewConstMetric(namespaceMaxBytesDesc, prometheus.GaugeValue, float64(ns.Namespace.MaxBytes), name)
+		}
+	}
+	if c.Membership != nil {
+		counts := make(map[string]int)
+		for _, m := range c.Membership.Members() {
+			counts[m.Status.String()]++
+		}
+		for status, count := range counts {
+			ch <- prometheus.MustNewConstMetric(membersDesc, prometheus.GaugeValue, float64(count), status)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	gometrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/serf/serf"
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	m, err := New(Config{
+		DB: db{},
+		Membership: membership{members: []serf.Member{
+			{Name: "0", Status: serf.StatusAlive},
+			{Name: "1", Status: serf.StatusAlive},
+			{Name: "2", Status: serf.StatusFailed},
+		}},
+	})
+	require.NoError(t, err)
+	defer m.Close()
+
+	gometrics.IncrCounter([]string{"raft", "apply"}, 1)
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	body := string(b)
+	for _, line := range []string{
+		"yass_kv_keys 3",
+		"yass_log_segments 2",
+		"yass_log_bytes 512",
+		"yass_raft_leader 1",
+		`yass_namespace_keys{namespace="team-a"} 4`,
+		`yass_namespace_max_bytes{namespace="team-a"} 1024`,
+		`yass_serf_members{status="alive"} 2`,
+		`yass_serf_members{status="failed"} 1`,
+		"yass_raft_apply 1",
+	} {
+		require.Contains(t, body, line)
+	}
+}
+
+func TestOneMetricsPerProcess(t *testing.T) {
+	m, err := New(Config{})
+	require.NoError(t, err)
+	_, err = New(Config{})
+	require.Equal(t, ErrInstalled, err)
+
+	require.NoError(t, m.Close())
+	m, err = New(Config{})
+	require.NoError(t, err)
+	require.NoError(t, m.Close())
+}
+
+type db struct{}
+
+func (db) Len() int {
+	return 3
+}
+
+func (db) LogStats() log.Stats {
+	return log.Stats{Segments: 2, Bytes: 512}
+}
+
+func (db) ListNamespaces() []*api.NamespaceUsage {
+	return []*api.NamespaceUsage{{
+		Namespace: &api.Namespace{Name: "team-a", MaxBytes: 1024},
+		Keys:      4,
+		Bytes:     100,
+	}}
+}
+
+func (db) IsLeader() bool {
+	return true
+}
+
+type membership struct {
+	members []serf.Member
+}
+
+func (m membership) Members() []serf.Member {
+	return m.members
+}
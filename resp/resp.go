@@ -0,0 +1,140 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxArgs bounds the arguments of a command sent as an array,
+// as Redis does
+const maxArgs = 1024 * 1024
+
+var errProtocol = errors.New("protocol error")
+
+// Reader reads the commands sent by a client
+type Reader struct {
+	r       *bufio.Reader
+	maxBulk int
+}
+
+// NewReader returns a Reader that rejects bulk strings
+// over maxBulk bytes
+func NewReader(r io.Reader, maxBulk int) *Reader {
+	return &Reader{r: bufio.NewReader(r), maxBulk: maxBulk}
+}
+
+// ReadCommand returns the next command and its arguments, either
+// sent as an array of bulk strings or inline separated by spaces
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	for {
+		line, err := r.line()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '*' {
+			return bytes.Fields(line), nil
+		}
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < 0 || n > maxArgs {
+			return nil, errProtocol
+		}
+		if n == 0 {
+			continue
+		}
+		// the count isn't trusted to size the arguments up front,
+		// they grow as they arrive
+		var args [][]byte
+		for i := 0; i < n; i++ {
+			arg, err := r.bulk()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return args, nil
+	}
+}
+
+func (r *Reader) bulk() ([]byte, error) {
+	line, err := r.line()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, errProtocol
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > r.maxBulk {
+		return nil, errProtocol
+	}
+	b := make([]byte, n+2)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(b, []byte("\r\n")) {
+		return nil, errProtocol
+	}
+	return b[:n], nil
+}
+
+// line returns the next line without its "\r\n"
+func (r *Reader) line() ([]byte, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errProtocol
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// Writer buffers replies until Flush is called
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// String writes a simple string, e.g. OK
+func (w *Writer) String(s string) {
+	fmt.Fprintf(w.w, "+%s\r\n", s)
+}
+
+// Error writes an error, which starts with its kind, e.g. "ERR syntax error"
+func (w *Writer) Error(msg string) {
+	fmt.Fprintf(w.w, "-%s\r\n", bytes.ReplaceAll([]byte(msg), []byte("\r\n"), []byte(" ")))
+}
+
+func (w *Writer) Integer(n int64) {
+	fmt.Fprintf(w.w, ":%d\r\n", n)
+}
+
+// Bulk writes a bulk string, or the null bulk string when b is nil
+func (w *Writer) Bulk(b []byte) {
+	if b == nil {
+		w.w.WriteString("$-1\r\n")
+		return
+	}
+	fmt.Fprintf(w.w, "$%d\r\n", len(b))
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+// Array starts an array of n elements, which are written next
+func (w *Writer) Array(n int) {
+	fmt.Fprintf(w.w, "*%d\r\n", n)
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
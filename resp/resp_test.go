@@ -0,0 +1,55 @@
+package resp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCommand(t *testing.T) {
+	r := NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$4\r\na\r\nb\r\n\r\nPING  hi\r\n*1\r\n+GET\r\n"), 16)
+
+	args, err := r.ReadCommand()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("GET"), []byte("a\r\nb")}, args)
+
+	// inline commands are split on spaces, blank lines are skipped
+	args, err = r.ReadCommand()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("PING"), []byte("hi")}, args)
+
+	_, err = r.ReadCommand()
+	require.Equal(t, errProtocol, err)
+
+	_, err = NewReader(strings.NewReader(""), 16).ReadCommand()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestReadCommandLimits(t *testing.T) {
+	// an array count is bounded before anything is allocated for it
+	_, err := NewReader(strings.NewReader("*1099511627776\r\n"), 16).ReadCommand()
+	require.Equal(t, errProtocol, err)
+
+	// a count within the bound still needs its arguments to arrive
+	_, err = NewReader(strings.NewReader("*1048576\r\n$3\r\nGET\r\n"), 16).ReadCommand()
+	require.Equal(t, io.EOF, err)
+
+	_, err = NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$17\r\n"), 16).ReadCommand()
+	require.Equal(t, errProtocol, err)
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Array(5)
+	w.String("OK")
+	w.Error("ERR bad\r\nthing")
+	w.Integer(-3)
+	w.Bulk([]byte("hi"))
+	w.Bulk(nil)
+	require.NoError(t, w.Flush())
+	require.Equal(t, "*5\r\n+OK\r\n-ERR bad thing\r\n:-3\r\n$2\r\nhi\r\n$-1\r\n", buf.String())
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/michael-diggin/yass/acl"
+	"github.com/michael-diggin/yass/api"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestKeyACLs(t *testing.T) {
+	acls := &acls{Rules: acl.NewRules()}
+	client, admin, teardown := setupTest(t, func(c *Config) {
+		c.ACLs = acls
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := admin.SetACL(ctx, &api.SetACLRequest{Rule: &api.ACLRule{
+		Subject: "client", Prefix: "team-a/", Read: true, Write: true,
+	}})
+	require.NoError(t, err)
+	_, err = admin.SetACL(ctx, &api.SetACLRequest{Rule: &api.ACLRule{
+		Subject: acl.Wildcard, Prefix: "public/", Read: true,
+	}})
+	require.NoError(t, err)
+
+	_, err = client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "team-a/key", Value: []byte("a")}})
+	require.NoError(t, err)
+	_, err = client.Get(ctx, &api.GetRequest{Id: "team-a/key"})
+	require.NoError(t, err)
+
+	_, err = client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "team-b/key", Value: []byte("b")}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "public/key", Value: []byte("p")}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Get(ctx, &api.GetRequest{Id: "public/key"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// keys in a namespace are matched as "<namespace>/<id>"
+	_, err = client.Set(ctx, &api.SetRequest{Record: &api.Record{Namespace: "team-a", Id: "key", Value: []byte("n")}})
+	require.NoError(t, err)
+	resp, err := client.Get(ctx, &api.GetRequest{Namespace: "team-a", Id: "key"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("n"), resp.Record.Value)
+	_, err = client.Set(ctx, &api.SetRequest{Record: &api.Record{Namespace: "team-b", Id: "key", Value: []byte("n")}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// scans only return the keys the caller may read
+	acls.Put(&api.ACLRule{Subject: "client", Prefix: "team-b/", Write: true})
+	_, err = client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "team-b/key", Value: []byte("b")}})
+	require.NoError(t, err)
+	acls.Delete("client", "team-b/")
+	scan, err := client.Scan(ctx, &api.ScanRequest{Prefix: "team-"})
+	require.NoError(t, err)
+	require.Len(t, scan.Records, 1)
+	require.Equal(t, "team-a/key", scan.Records[0].Id)
+
+	list, err := admin.ListACLs(ctx, &api.ListACLsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.Rules, 2)
+	require.Equal(t, acl.Wildcard, list.Rules[0].Subject)
+
+	_, err = admin.DeleteACL(ctx, &api.DeleteACLRequest{Subject: "client", Prefix: "team-a/"})
+	require.NoError(t, err)
+	_, err = client.Get(ctx, &api.GetRequest{Id: "team-a/key"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = admin.SetACL(ctx, &api.SetACLRequest{Rule: &api.ACLRule{Prefix: "x"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestReservedKeysRejected(t *testing.T) {
+	client, _, teardown := setupTest(t, nil)
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: acl.Key("client", ""), Value: []byte("x")}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.Get(ctx, &api.GetRequest{Id: acl.Key("client", "")})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestACLsNotEnabled(t *testing.T) {
+	_, admin, teardown := setupTest(t, nil)
+	defer teardown()
+
+	_, err := admin.ListACLs(context.Background(), &api.ListACLsRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// acls keeps rules in memory instead of replicating them
+type acls struct {
+	*acl.Rules
+}
+
+func (a *acls) SetACL(ctx context.Context, rule *api.ACLRule) (uint64, error) {
+	a.Put(rule)
+	return 0, nil
+}
+
+func (a *acls) DeleteACL(ctx context.Context, subject, prefix string) (uint64, error) {
+	a.Delete(subject, prefix)
+	return 0, nil
+}
+
+func (a *acls) ListACLs() []*api.ACLRule {
+	return a.List()
+}
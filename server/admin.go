@@ -0,0 +1,472 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/hashicorp/raft"
+	"github.com/hashicorp/serf/serf"
+	"github.com/michael-diggin/yass/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Cluster is the Raft group operated through the admin service
+type Cluster interface {
+	Stats() map[string]string
+	Servers() ([]raft.Server, error)
+	Leader() string
+	AddVoter(id, addr string) error
+	RemoveServer(id string) error
+	Snapshot() (uint64, error)
+	TransferLeadership(id, addr string) error
+	// Backup writes a backup archive of a snapshot of the FSM
+	Backup(w io.Writer) error
+}
+
+// ACLs are the key prefix rules managed through the admin service
+type ACLs interface {
+	// SetACL and DeleteACL return the Raft index they were applied at
+	SetACL(ctx context.Context, rule *api.ACLRule) (uint64, error)
+	DeleteACL(ctx context.Context, subject, prefix string) (uint64, error)
+	ListACLs() []*api.ACLRule
+	Allowed(subject, key string, write bool) bool
+}
+
+// Namespaces are the key namespaces managed through the admin service
+type Namespaces interface {
+	// CreateNamespace and DropNamespace return the Raft
+	// index they were applied at
+	CreateNamespace(ctx context.Context, ns *api.Namespace) (uint64, error)
+	DropNamespace(ctx context.Context, name string) (uint64, error)
+	ListNamespaces() []*api.NamespaceUsage
+}
+
+// Bulk exports and imports records through the admin service
+type Bulk interface {
+	Records(afterNamespace, afterID string) []*api.Record
+	// SetBatch sets the records in order, returning how many were
+	// set before one failed and the Raft index they were applied at
+	SetBatch(ctx context.Context, records []*api.Record) (int, uint64, error)
+}
+
+type Membership interface {
+	Members() []serf.Member
+}
+
+// GossipKeys manages the keyring serf gossip is encrypted with
+type GossipKeys interface {
+	InstallKey(key string) (*serf.KeyResponse, error)
+	UseKey(key string) (*serf.KeyResponse, error)
+	RemoveKey(key string) (*serf.KeyResponse, error)
+	ListKeys() (*serf.KeyResponse, error)
+}
+
+var _ api.AdminServer = (*adminServer)(nil)
+
+type adminServer struct {
+	api.UnimplementedAdminServer
+	*Config
+	// batches runs each batch of an Import through callInterceptors
+	batches grpc.UnaryServerInterceptor
+}
+
+func newadminServer(config *Config) (*adminServer, error) {
+	return &adminServer{
+		Config:  config,
+		batches: grpc_middleware.ChainUnaryServer(callInterceptors(config)...),
+	}, nil
+}
+
+func (s *adminServer) RaftStats(ctx context.Context, req *api.RaftStatsRequest) (*api.RaftStatsResponse, error) {
+	if s.Cluster == nil {
+		return nil, errNoCluster
+	}
+	servers, err := s.Cluster.Servers()
+	if err != nil {
+		return nil, err
+	}
+	leader := s.Cluster.Leader()
+	resp := &api.RaftStatsResponse{Stats: s.Cluster.Stats()}
+	for _, srv := range servers {
+		resp.Servers = append(resp.Servers, &api.Server{
+			Id:       string(srv.ID),
+			RpcAddr:  string(srv.Address),
+			IsLeader: string(srv.Address) == leader,
+			Suffrage: srv.Suffrage.String(),
+		})
+	}
+	return resp, nil
+}
+
+func (s *adminServer) AddVoter(ctx context.Context, req *api.AddVoterRequest) (*api.AddVoterResponse, error) {
+	if s.Cluster == nil {
+		return nil, errNoCluster
+	}
+	if req.Id == "" || req.Addr == "" {
+		return nil, status.Error(codes.InvalidArgument, "id and addr are required")
+	}
+	if err := s.Cluster.AddVoter(req.Id, req.Addr); err != nil {
+		return nil, raftError(err)
+	}
+	return &api.AddVoterResponse{}, nil
+}
+
+func (s *adminServer) RemoveServer(ctx context.Context, req *api.RemoveServerRequest) (*api.RemoveServerResponse, error) {
+	if s.Cluster == nil {
+		return nil, errNoCluster
+	}
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	if err := s.Cluster.RemoveServer(req.Id); err != nil {
+		return nil, raftError(err)
+	}
+	return &api.RemoveServerResponse{}, nil
+}
+
+func (s *adminServer) TriggerSnapshot(ctx context.Context, req *api.TriggerSnapshotRequest) (*api.TriggerSnapshotResponse, error) {
+	if s.Cluster == nil {
+		return nil, errNoCluster
+	}
+	index, err := s.Cluster.Snapshot()
+	if err != nil {
+		return nil, raftError(err)
+	}
+	return &api.TriggerSnapshotResponse{Index: index}, nil
+}
+
+func (s *adminServer) TransferLeadership(ctx context.Context, req *api.TransferLeadershipRequest) (*api.TransferLeadershipResponse, error) {
+	if s.Cluster == nil {
+		return nil, errNoCluster
+	}
+	if err := s.Cluster.TransferLeadership(req.Id, req.Addr); err != nil {
+		return nil, raftError(err)
+	}
+	return &api.TransferLeadershipResponse{}, nil
+}
+
+func (s *adminServer) ListMembers(ctx context.Context, req *api.ListMembersRequest) (*api.ListMembersResponse, error) {
+	if s.Membership == nil {
+		return nil, status.Error(codes.Unavailable, "membership is not enabled")
+	}
+	resp := &api.ListMembersResponse{}
+	for _, m := range s.Membership.Members() {
+		resp.Members = append(resp.Members, &api.Member{
+			Name:   m.Name,
+			Addr:   m.Addr.String(),
+			Status: m.Status.String(),
+			Tags:   m.Tags,
+		})
+	}
+	return resp, nil
+}
+
+func (s *adminServer) SetACL(ctx context.Context, req *api.SetACLRequest) (*api.SetACLResponse, error) {
+	if s.ACLs == nil {
+		return nil, errNoACLs
+	}
+	if req.Rule.GetSubject() == "" {
+		return nil, status.Error(codes.InvalidArgument, "subject is required")
+	}
+	index, err := s.ACLs.SetACL(ctx, req.Rule)
+	setIndex(ctx, index)
+	if err != nil {
+		return nil, raftError(err)
+	}
+	return &api.SetACLResponse{}, nil
+}
+
+func (s *adminServer) DeleteACL(ctx context.Context, req *api.DeleteACLRequest) (*api.DeleteACLResponse, error) {
+	if s.ACLs == nil {
+		return nil, errNoACLs
+	}
+	if req.Subject == "" {
+		return nil, status.Error(codes.InvalidArgument, "subject is required")
+	}
+	index, err := s.ACLs.DeleteACL(ctx, req.Subject, req.Prefix)
+	setIndex(ctx, index)
+	if err != nil {
+		return nil, raftError(err)
+	}
+	return &api.DeleteACLResponse{}, nil
+}
+
+func (s *adminServer) ListACLs(ctx context.Context, req *api.ListACLsRequest) (*api.ListACLsResponse, error) {
+	if s.ACLs == nil {
+		return nil, errNoACLs
+	}
+	return &api.ListACLsResponse{Rules: s.ACLs.ListACLs()}, nil
+}
+
+func (s *adminServer) CreateNamespace(ctx context.Context, req *api.CreateNamespaceRequest) (*api.CreateNamespaceResponse, error) {
+	if s.Namespaces == nil {
+		return nil, errNoNamespaces
+	}
+	if !validNamespace(req.Namespace.GetName()) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid namespace name %q", req.Namespace.GetName())
+	}
+	index, err := s.Namespaces.CreateNamespace(ctx, req.Namespace)
+	setIndex(ctx, index)
+	if err != nil {
+		return nil, raftError(err)
+	}
+	return &api.CreateNamespaceResponse{}, nil
+}
+
+func (s *adminServer) ListNamespaces(ctx context.Context, req *api.ListNamespacesRequest) (*api.ListNamespacesResponse, error) {
+	if s.Namespaces == nil {
+		return nil, errNoNamespaces
+	}
+	return &api.ListNamespacesResponse{Namespaces: s.Namespaces.ListNamespaces()}, nil
+}
+
+func (s *adminServer) DropNamespace(ctx context.Context, req *api.DropNamespaceRequest) (*api.DropNamespaceResponse, error) {
+	if s.Namespaces == nil {
+		return nil, errNoNamespaces
+	}
+	index, err := s.Namespaces.DropNamespace(ctx, req.Name)
+	setIndex(ctx, index)
+	if err != nil {
+		return nil, raftError(err)
+	}
+	return &api.DropNamespaceResponse{}, nil
+}
+
+// validNamespace reports whether a name is made up of
+// letters, digits, '-', '_' and '.'
+func validNamespace(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (s *adminServer) InstallGossipKey(ctx context.Context, req *api.GossipKeyRequest) (*api.GossipKeyResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	return s.gossipKeyOp(func(gk GossipKeys) (*serf.KeyResponse, error) {
+		return gk.InstallKey(req.Key)
+	})
+}
+
+func (s *adminServer) UseGossipKey(ctx context.Context, req *api.GossipKeyRequest) (*api.GossipKeyResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	return s.gossipKeyOp(func(gk GossipKeys) (*serf.KeyResponse, error) {
+		return gk.UseKey(req.Key)
+	})
+}
+
+func (s *adminServer) RemoveGossipKey(ctx context.Context, req *api.GossipKeyRequest) (*api.GossipKeyResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	return s.gossipKeyOp(func(gk GossipKeys) (*serf.KeyResponse, error) {
+		return gk.RemoveKey(req.Key)
+	})
+}
+
+func (s *adminServer) ListGossipKeys(ctx context.Context, req *api.ListGossipKeysRequest) (*api.GossipKeyResponse, error) {
+	return s.gossipKeyOp(func(gk GossipKeys) (*serf.KeyResponse, error) {
+		return gk.ListKeys()
+	})
+}
+
+// gossipKeyOp runs a keyring operation, an operation that
+// fails on any member returns FailedPrecondition
+func (s *adminServer) gossipKeyOp(op func(GossipKeys) (*serf.KeyResponse, error)) (*api.GossipKeyResponse, error) {
+	if s.GossipKeys == nil {
+		return nil, status.Error(codes.Unavailable, "gossip encryption is not enabled")
+	}
+	resp, err := op(s.GossipKeys)
+	if err != nil {
+		st := status.New(codes.FailedPrecondition, err.Error())
+		if resp != nil && len(resp.Messages) > 0 {
+			st = status.Newf(codes.FailedPrecondition, "%s: %v", err, resp.Messages)
+		}
+		return nil, st.Err()
+	}
+	out := &api.GossipKeyResponse{
+		NumNodes:    int32(resp.NumNodes),
+		NumResp:     int32(resp.NumResp),
+		NumErr:      int32(resp.NumErr),
+		Messages:    resp.Messages,
+		Keys:        make(map[string]int32),
+		PrimaryKeys: make(map[string]int32),
+	}
+	for k, n := range resp.Keys {
+		out.Keys[k] = int32(n)
+	}
+	for k, n := range resp.PrimaryKeys {
+		out.PrimaryKeys[k] = int32(n)
+	}
+	return out, nil
+}
+
+var errNoNamespaces = status.Error(codes.Unavailable, "namespaces are not enabled")
+
+var errNoACLs = status.Error(codes.Unavailable, "ACLs are not enabled")
+
+var errNoBulk = status.Error(codes.Unavailable, "bulk import and export are not enabled")
+
+var errNoCluster = status.Error(codes.Unavailable, "cluster operations are not enabled")
+
+// backupChunkBytes is the most each BackupChunk holds
+const backupChunkBytes = 1 << 20
+
+// Backup streams a backup archive of a snapshot of the cluster
+func (s *adminServer) Backup(req *api.BackupRequest, stream api.Admin_BackupServer) error {
+	if s.Cluster == nil {
+		return errNoCluster
+	}
+	w := bufio.NewWriterSize(chunkWriter{stream}, backupChunkBytes)
+	if err := s.Cluster.Backup(w); err != nil {
+		return raftError(err)
+	}
+	return w.Flush()
+}
+
+// chunkWriter sends what's written as BackupChunks
+type chunkWriter struct {
+	stream api.Admin_BackupServer
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > backupChunkBytes {
+			chunk = chunk[:backupChunkBytes]
+		}
+		if err := w.stream.Send(&api.BackupChunk{Data: chunk}); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+const (
+	defaultExportBatch = 500
+	maxBatch           = 10000
+	importMethod       = adminMethodPrefix + "Import"
+)
+
+// Export streams every record in batches, each reporting the progress
+// of the export. An interrupted export is resumed after the last
+// record received
+func (s *adminServer) Export(req *api.ExportRequest, stream api.Admin_ExportServer) error {
+	if s.Bulk == nil {
+		return errNoBulk
+	}
+	size := int(req.BatchSize)
+	switch {
+	case size < 0 || size > maxBatch:
+		return status.Errorf(codes.InvalidArgument, "batch size must be at most %d", maxBatch)
+	case size == 0:
+		size = defaultExportBatch
+	}
+	records := s.Bulk.Records(req.AfterNamespace, req.AfterId)
+	total := uint64(len(records))
+	for i := 0; i < len(records); i += size {
+		batch := records[i:]
+		if len(batch) > size {
+			batch = batch[:size]
+		}
+		if err := stream.Send(&api.ExportBatch{
+			Records:  batch,
+			Exported: uint64(i + len(batch)),
+			Total:    total,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import sets each batch received through a single Raft entry and
+// acknowledges it with the records imported so far, which are also
+// sent before an error so the import can be resumed
+func (s *adminServer) Import(stream api.Admin_ImportServer) error {
+	if s.Bulk == nil {
+		return errNoBulk
+	}
+	info := &grpc.UnaryServerInfo{Server: s, FullMethod: importMethod}
+	var imported uint64
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(batch.Records) > maxBatch {
+			return status.Errorf(codes.InvalidArgument, "batches hold at most %d records", maxBatch)
+		}
+		resp, err := s.batches(stream.Context(), batch, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return s.importBatch(ctx, req.(*api.ImportBatch))
+		})
+		if progress, ok := resp.(*api.ImportProgress); ok {
+			imported += progress.Imported
+		}
+		if sendErr := stream.Send(&api.ImportProgress{Imported: imported}); sendErr != nil {
+			return sendErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// importBatch sets the batch's records up to the first that's invalid
+// or the caller may not write, returning how many were set
+func (s *adminServer) importBatch(ctx context.Context, batch *api.ImportBatch) (*api.ImportProgress, error) {
+	records, invalid := batch.Records, error(nil)
+	for i, record := range records {
+		if invalid = s.validateRecord(record); invalid == nil {
+			invalid = s.checkKey(ctx, record.Namespace, record.Id, true)
+		}
+		if invalid != nil {
+			records = records[:i]
+			break
+		}
+	}
+	n, err := 0, error(nil)
+	if len(records) > 0 {
+		var index uint64
+		n, index, err = s.Bulk.SetBatch(ctx, records)
+		setIndex(ctx, index)
+	}
+	progress := &api.ImportProgress{Imported: uint64(n)}
+	if err != nil {
+		return progress, raftError(err)
+	}
+	return progress, invalid
+}
+
+// raftError maps errors from Raft onto gRPC status codes
+func raftError(err error) error {
+	switch err {
+	case raft.ErrNotLeader, raft.ErrLeadershipTransferInProgress:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case raft.ErrNothingNewToSnapshot:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return err
+}
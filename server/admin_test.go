@@ -0,0 +1,361 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/hashicorp/serf/serf"
+	"github.com/michael-diggin/yass/acl"
+	"github.com/michael-diggin/yass/api"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminClusterOperations(t *testing.T) {
+	cluster := &cluster{servers: []raft.Server{
+		{ID: "0", Address: "addr-0", Suffrage: raft.Voter},
+	}}
+	_, admin, teardown := setupTest(t, func(c *Config) {
+		c.Cluster = cluster
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := admin.AddVoter(ctx, &api.AddVoterRequest{Id: "1", Addr: "addr-1"})
+	require.NoError(t, err)
+
+	stats, err := admin.RaftStats(ctx, &api.RaftStatsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "Leader", stats.Stats["state"])
+	require.Len(t, stats.Servers, 2)
+	require.True(t, stats.Servers[0].IsLeader)
+	require.Equal(t, "Voter", stats.Servers[1].Suffrage)
+
+	_, err = admin.RemoveServer(ctx, &api.RemoveServerRequest{Id: "1"})
+	require.NoError(t, err)
+	require.Len(t, cluster.servers, 1)
+
+	_, err = admin.RemoveServer(ctx, &api.RemoveServerRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	snap, err := admin.TriggerSnapshot(ctx, &api.TriggerSnapshotRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), snap.Index)
+
+	_, err = admin.TransferLeadership(ctx, &api.TransferLeadershipRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	stream, err := admin.Backup(ctx, &api.BackupRequest{})
+	require.NoError(t, err)
+	var chunks, size int
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		chunks++
+		size += len(chunk.Data)
+	}
+	require.Equal(t, 2, chunks)
+	require.Equal(t, backupChunkBytes+10, size)
+}
+
+func TestAdminDeniedWithoutAuthorizer(t *testing.T) {
+	_, admin, teardown := setupTest(t, func(c *Config) {
+		c.Authorizer = nil
+	})
+	defer teardown()
+
+	_, err := admin.RaftStats(context.Background(), &api.RaftStatsRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestAdminNotEnabled(t *testing.T) {
+	_, admin, teardown := setupTest(t, nil)
+	defer teardown()
+
+	_, err := admin.ListMembers(context.Background(), &api.ListMembersRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestAdminNamespaces(t *testing.T) {
+	namespaces := &namespaces{}
+	_, admin, teardown := setupTest(t, func(c *Config) {
+		c.Namespaces = namespaces
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := admin.CreateNamespace(ctx, &api.CreateNamespaceRequest{
+		Namespace: &api.Namespace{Name: "team-a", MaxKeys: 10},
+	})
+	require.NoError(t, err)
+	for _, name := range []string{"", "team/a", "team\x00a"} {
+		_, err = admin.CreateNamespace(ctx, &api.CreateNamespaceRequest{
+			Namespace: &api.Namespace{Name: name},
+		})
+		require.Equal(t, codes.InvalidArgument, status.Code(err), name)
+	}
+
+	list, err := admin.ListNamespaces(ctx, &api.ListNamespacesRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.Namespaces, 1)
+	require.Equal(t, uint64(10), list.Namespaces[0].Namespace.MaxKeys)
+
+	_, err = admin.DropNamespace(ctx, &api.DropNamespaceRequest{Name: "team-a"})
+	require.NoError(t, err)
+	_, err = admin.DropNamespace(ctx, &api.DropNamespaceRequest{Name: "team-a"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAdminGossipKeys(t *testing.T) {
+	keys := &gossipKeys{keys: map[string]int{"old": 3}}
+	_, admin, teardown := setupTest(t, func(c *Config) {
+		c.GossipKeys = keys
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := admin.InstallGossipKey(ctx, &api.GossipKeyRequest{Key: "new"})
+	require.NoError(t, err)
+	resp, err := admin.ListGossipKeys(ctx, &api.ListGossipKeysRequest{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int32{"old": 3, "new": 3}, resp.Keys)
+	require.Equal(t, int32(3), resp.NumResp)
+
+	_, err = admin.RemoveGossipKey(ctx, &api.GossipKeyRequest{Key: "old"})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "key is in use")
+
+	_, err = admin.UseGossipKey(ctx, &api.GossipKeyRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAdminExportImport(t *testing.T) {
+	bulk := &bulk{}
+	_, admin, teardown := setupTest(t, func(c *Config) {
+		c.Bulk = bulk
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	imp, err := admin.Import(ctx)
+	require.NoError(t, err)
+	require.NoError(t, imp.Send(&api.ImportBatch{Records: []*api.Record{
+		{Id: "a", Value: []byte("1")},
+		{Id: "b", Value: []byte("2")},
+	}}))
+	progress, err := imp.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), progress.Imported)
+
+	// the valid records before an invalid one are still imported
+	require.NoError(t, imp.Send(&api.ImportBatch{Records: []*api.Record{
+		{Id: "c", Value: []byte("3")},
+		{Id: "", Value: []byte("4")},
+		{Id: "d", Value: []byte("5")},
+	}}))
+	progress, err = imp.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), progress.Imported)
+	_, err = imp.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Len(t, bulk.records, 3)
+
+	exp, err := admin.Export(ctx, &api.ExportRequest{AfterId: "a", BatchSize: 1})
+	require.NoError(t, err)
+	var ids []string
+	for {
+		batch, err := exp.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.Len(t, batch.Records, 1)
+		require.Equal(t, uint64(2), batch.Total)
+		require.Equal(t, uint64(len(ids)+1), batch.Exported)
+		ids = append(ids, batch.Records[0].Id)
+	}
+	require.Equal(t, []string{"b", "c"}, ids)
+
+	exp, err = admin.Export(ctx, &api.ExportRequest{BatchSize: maxBatch + 1})
+	require.NoError(t, err)
+	_, err = exp.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAdminImportChecks(t *testing.T) {
+	bulk, auditor := &bulk{}, &auditor{}
+	acls := &acls{Rules: acl.NewRules()}
+	acls.Put(&api.ACLRule{Subject: "admin", Prefix: "team-a/", Write: true})
+	_, admin, teardown := setupTest(t, func(c *Config) {
+		c.Bulk = bulk
+		c.Auditor = auditor
+		c.ACLs = acls
+		c.RateLimits = &RateLimits{Methods: map[string]Limit{
+			importMethod: {Rate: 0.001, Burst: 2},
+		}}
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	imp, err := admin.Import(ctx)
+	require.NoError(t, err)
+	require.NoError(t, imp.Send(&api.ImportBatch{Records: []*api.Record{
+		{Id: "team-a/1", Value: []byte("1")},
+	}}))
+	progress, err := imp.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), progress.Imported)
+
+	// each batch is audited and rate limited like a call
+	require.NoError(t, imp.Send(&api.ImportBatch{Records: []*api.Record{
+		{Id: "team-a/2", Value: []byte("2")},
+		{Id: "team-b/1", Value: []byte("3")},
+	}}))
+	progress, err = imp.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), progress.Imported)
+	_, err = imp.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.Len(t, bulk.records, 2)
+
+	imp, err = admin.Import(ctx)
+	require.NoError(t, err)
+	require.NoError(t, imp.Send(&api.ImportBatch{Records: []*api.Record{
+		{Id: "team-a/3", Value: []byte("4")},
+	}}))
+	progress, err = imp.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), progress.Imported)
+	_, err = imp.Recv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	require.Len(t, auditor.entries, 3)
+	require.Equal(t, importMethod, auditor.entries[0].Method)
+	require.Equal(t, "batch of 1", auditor.entries[0].Target)
+	require.Equal(t, codes.PermissionDenied.String(), auditor.entries[1].Code)
+	require.Equal(t, codes.ResourceExhausted.String(), auditor.entries[2].Code)
+}
+
+type bulk struct {
+	records []*api.Record
+}
+
+func (b *bulk) Records(afterNamespace, afterID string) []*api.Record {
+	var records []*api.Record
+	for _, record := range b.records {
+		if record.Namespace > afterNamespace ||
+			(record.Namespace == afterNamespace && record.Id > afterID) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func (b *bulk) SetBatch(ctx context.Context, records []*api.Record) (int, uint64, error) {
+	b.records = append(b.records, records...)
+	return len(records), 0, nil
+}
+
+type gossipKeys struct {
+	keys map[string]int
+}
+
+func (g *gossipKeys) InstallKey(key string) (*serf.KeyResponse, error) {
+	g.keys[key] = 3
+	return &serf.KeyResponse{NumNodes: 3, NumResp: 3}, nil
+}
+
+func (g *gossipKeys) UseKey(key string) (*serf.KeyResponse, error) {
+	return &serf.KeyResponse{NumNodes: 3, NumResp: 3}, nil
+}
+
+func (g *gossipKeys) RemoveKey(key string) (*serf.KeyResponse, error) {
+	return &serf.KeyResponse{
+		NumNodes: 3, NumResp: 3, NumErr: 3,
+		Messages: map[string]string{"0": "key is in use"},
+	}, errors.New("3/3 nodes reported failure")
+}
+
+func (g *gossipKeys) ListKeys() (*serf.KeyResponse, error) {
+	return &serf.KeyResponse{NumNodes: 3, NumResp: 3, Keys: g.keys}, nil
+}
+
+type namespaces struct {
+	list []*api.NamespaceUsage
+}
+
+func (n *namespaces) CreateNamespace(ctx context.Context, ns *api.Namespace) (uint64, error) {
+	n.list = append(n.list, &api.NamespaceUsage{Namespace: ns})
+	return uint64(len(n.list)), nil
+}
+
+func (n *namespaces) DropNamespace(ctx context.Context, name string) (uint64, error) {
+	for i, ns := range n.list {
+		if ns.Namespace.Name == name {
+			n.list = append(n.list[:i], n.list[i+1:]...)
+			return 0, nil
+		}
+	}
+	return 0, api.ErrNamespaceNotFound{Name: name}
+}
+
+func (n *namespaces) ListNamespaces() []*api.NamespaceUsage {
+	return n.list
+}
+
+type cluster struct {
+	servers []raft.Server
+}
+
+func (c *cluster) Stats() map[string]string {
+	return map[string]string{"state": "Leader"}
+}
+
+func (c *cluster) Servers() ([]raft.Server, error) {
+	return c.servers, nil
+}
+
+func (c *cluster) Leader() string {
+	return string(c.servers[0].Address)
+}
+
+func (c *cluster) AddVoter(id, addr string) error {
+	c.servers = append(c.servers, raft.Server{
+		ID:       raft.ServerID(id),
+		Address:  raft.ServerAddress(addr),
+		Suffrage: raft.Voter,
+	})
+	return nil
+}
+
+func (c *cluster) RemoveServer(id string) error {
+	servers := c.servers[:0]
+	for _, srv := range c.servers {
+		if string(srv.ID) != id {
+			servers = append(servers, srv)
+		}
+	}
+	c.servers = servers
+	return nil
+}
+
+func (c *cluster) Snapshot() (uint64, error) {
+	return 7, nil
+}
+
+func (c *cluster) TransferLeadership(id, addr string) error {
+	return raft.ErrLeadershipTransferInProgress
+}
+
+func (c *cluster) Backup(w io.Writer) error {
+	_, err := w.Write(bytes.Repeat([]byte("b"), backupChunkBytes+10))
+	return err
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/audit"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Auditor records the mutating calls made to the server
+type Auditor interface {
+	Write(e audit.Entry) error
+}
+
+// auditUnary writes an entry for every mutating call, including
+// those that are denied, once the call has completed
+func auditUnary(config *Config) grpc.UnaryServerInterceptor {
+	logger := zap.L().Named("audit")
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if readOnlyMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		ctx = withIndex(ctx)
+		resp, err := handler(ctx, req)
+
+		e := audit.Entry{
+			Time:      time.Now().UTC(),
+			Subject:   subject(ctx),
+			Method:    info.FullMethod,
+			Code:      status.Code(err).String(),
+			RaftIndex: appliedIndex(ctx),
+		}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		describe(&e, req)
+		if werr := config.Auditor.Write(e); werr != nil {
+			logger.Error("failed to write audit entry", zap.Error(werr), zap.String("method", info.FullMethod))
+		}
+		return resp, err
+	}
+}
+
+// describe sets what the request acted on
+func describe(e *audit.Entry, req interface{}) {
+	switch req := req.(type) {
+	case *api.SetRequest:
+		e.Namespace = req.Record.GetNamespace()
+		e.Key = req.Record.GetId()
+	case *api.AddVoterRequest:
+		e.Target = req.Id
+	case *api.RemoveServerRequest:
+		e.Target = req.Id
+	case *api.TransferLeadershipRequest:
+		e.Target = req.Id
+	case *api.SetACLRequest:
+		e.Target = req.Rule.GetSubject() + " " + req.Rule.GetPrefix()
+	case *api.DeleteACLRequest:
+		e.Target = req.Subject + " " + req.Prefix
+	case *api.CreateNamespaceRequest:
+		e.Namespace = req.Namespace.GetName()
+	case *api.DropNamespaceRequest:
+		e.Namespace = req.Name
+	case *api.ImportBatch:
+		e.Target = fmt.Sprintf("batch of %d", len(req.Records))
+	}
+}
+
+type indexKey struct{}
+
+// withIndex returns a context the handler records the Raft
+// index of the call's write in with setIndex
+func withIndex(ctx context.Context) context.Context {
+	return context.WithValue(ctx, indexKey{}, new(uint64))
+}
+
+func setIndex(ctx context.Context, index uint64) {
+	if p, ok := ctx.Value(indexKey{}).(*uint64); ok {
+		atomic.StoreUint64(p, index)
+	}
+}
+
+func appliedIndex(ctx context.Context) uint64 {
+	if p, ok := ctx.Value(indexKey{}).(*uint64); ok {
+		return atomic.LoadUint64(p)
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/audit"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestAuditMutatingCalls(t *testing.T) {
+	auditor := &auditor{}
+	client, admin, teardown := setupTest(t, func(c *Config) {
+		c.Auditor = auditor
+		c.Namespaces = &namespaces{}
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "key", Value: []byte("v")}})
+	require.NoError(t, err)
+	_, err = client.Get(ctx, &api.GetRequest{Id: "key"})
+	require.NoError(t, err)
+	_, err = admin.RemoveServer(ctx, &api.RemoveServerRequest{Id: "1"})
+	require.Error(t, err)
+	_, err = admin.CreateNamespace(ctx, &api.CreateNamespaceRequest{Namespace: &api.Namespace{Name: "team-a"}})
+	require.NoError(t, err)
+
+	require.Len(t, auditor.entries, 3)
+	set := auditor.entries[0]
+	require.Equal(t, "client", set.Subject)
+	require.Equal(t, "/api.Storage/Set", set.Method)
+	require.Equal(t, "key", set.Key)
+	require.Equal(t, codes.OK.String(), set.Code)
+
+	remove := auditor.entries[1]
+	require.Equal(t, "admin", remove.Subject)
+	require.Equal(t, "1", remove.Target)
+	require.Equal(t, codes.Unavailable.String(), remove.Code)
+	require.NotEmpty(t, remove.Error)
+
+	// the entry records the Raft index the write was applied at
+	create := auditor.entries[2]
+	require.Equal(t, uint64(1), create.RaftIndex)
+}
+
+type auditor struct {
+	entries []audit.Entry
+}
+
+func (a *auditor) Write(e audit.Entry) error {
+	a.entries = append(a.entries, e)
+	return nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const adminMethodPrefix = "/api.Admin/"
+
+// Authorizer decides whether a certificate subject may call a method
+type Authorizer interface {
+	Authorize(subject, method string) error
+}
+
+func authorizeUnary(config *Config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := authorize(ctx, config, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func authorizeStream(config *Config) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorize(ss.Context(), config, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// authorize checks the caller against Config.Authorizer. Without one,
+// the storage service is open and the admin service is closed.
+// Health checks and reflection are open to every caller
+func authorize(ctx context.Context, config *Config, method string) error {
+	if strings.HasPrefix(method, healthMethodPrefix) || strings.HasPrefix(method, reflectionMethodPrefix) {
+		return nil
+	}
+	sub := subject(ctx)
+	if config.Authorizer != nil {
+		return config.Authorizer.Authorize(sub, method)
+	}
+	if strings.HasPrefix(method, adminMethodPrefix) {
+		return status.Errorf(codes.PermissionDenied, "%q is not permitted to call %s", sub, method)
+	}
+	return nil
+}
+
+// subject returns the common name of the client's verified certificate
+func subject(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return ""
+	}
+	return tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/michael-diggin/yass/api"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const keysPath = "/v1/keys"
+
+// gateway serves the storage service as HTTP/JSON
+type gateway struct {
+	srv   *grpcServer
+	chain grpc.UnaryServerInterceptor
+}
+
+// NewHTTPHandler returns an HTTP/JSON gateway to the storage service:
+//
+//	GET    /v1/keys/{id}         reads a key
+//	PUT    /v1/keys/{id}         sets a key to the JSON record in the body
+//	DELETE /v1/keys/{id}         deletes a key
+//	GET    /v1/keys?prefix=&limit= scans keys
+//
+// Keys in a namespace take a namespace query parameter
+func NewHTTPHandler(config *Config) http.Handler {
+	logger := zap.L().Named("gateway")
+	g := &gateway{
+		srv:   &grpcServer{Config: config},
+		chain: grpc_middleware.ChainUnaryServer(unaryInterceptors(config, logger)...),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(keysPath, g.scan)
+	mux.HandleFunc(keysPath+"/", g.key)
+	return mux
+}
+
+func (g *gateway) key(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, keysPath+"/")
+	namespace := r.URL.Query().Get("namespace")
+	switch r.Method {
+	case http.MethodGet:
+		resp, err := g.call(r, "/api.Storage/Get", &api.GetRequest{Id: id, Namespace: namespace},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return g.srv.Get(ctx, req.(*api.GetRequest))
+			})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, resp.(*api.GetResponse).Record)
+	case http.MethodPut, http.MethodDelete:
+		record := &api.Record{Deleted: true}
+		if r.Method == http.MethodPut {
+			var err error
+			if record, err = g.readRecord(r); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		record.Id, record.Namespace = id, namespace
+		_, err := g.call(r, "/api.Storage/Set", &api.SetRequest{Record: record},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return g.srv.Set(ctx, req.(*api.SetRequest))
+			})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, status.Errorf(codes.Unimplemented, "method %s not allowed", r.Method))
+	}
+}
+
+func (g *gateway) scan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, status.Errorf(codes.Unimplemented, "method %s not allowed", r.Method))
+		return
+	}
+	req := &api.ScanRequest{Prefix: r.URL.Query().Get("prefix")}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 32)
+		if err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "invalid limit %q", limit))
+			return
+		}
+		req.Limit = int32(n)
+	}
+	resp, err := g.call(r, "/api.Storage/Scan", req,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return g.srv.Scan(ctx, req.(*api.ScanRequest))
+		})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, resp.(*api.ScanResponse))
+}
+
+// readRecord decodes the body, which is bounded by the
+// JSON encoding of a record holding the largest value
+func (g *gateway) readRecord(r *http.Request) (*api.Record, error) {
+	max := int64(g.srv.maxValueBytes())*4/3 + 1<<10
+	b, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, max))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to read body: %v", err)
+	}
+	record := &api.Record{}
+	if err := protojson.Unmarshal(b, record); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid record: %v", err)
+	}
+	return record, nil
+}
+
+// call runs the handler as the gRPC method, with the
+// client's verified certificate as its peer
+func (g *gateway) call(r *http.Request, method string, req interface{}, handler grpc.UnaryHandler) (interface{}, error) {
+	p := &peer.Peer{Addr: httpAddr(r.RemoteAddr)}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{State: *r.TLS}
+	}
+	ctx := peer.NewContext(r.Context(), p)
+	return g.chain(ctx, req, &grpc.UnaryServerInfo{Server: g.srv, FullMethod: method}, handler)
+}
+
+// httpAddr is the remote address of an HTTP request
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
+
+func writeJSON(w http.ResponseWriter, m proto.Message) {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// writeError writes the error's status as JSON with the
+// HTTP status code closest to its gRPC code
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus(st.Code()))
+	json.NewEncoder(w).Encode(struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{st.Code().String(), st.Message()})
+}
+
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusMethodNotAllowed
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Canceled:
+		return 499
+	default:
+		return http.StatusInternalServerError
+	}
+}
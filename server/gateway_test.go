@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/kv"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestHTTPGateway(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gateway-test")
+	require.NoError(t, err)
+	db, err := kv.NewDB(dir, kv.Config{})
+	require.NoError(t, err)
+	defer db.Clear()
+
+	srv := httptest.NewServer(NewHTTPHandler(&Config{
+		DB:         localDB{db},
+		Authorizer: authorizer{"/api.Storage/Get", "/api.Storage/Set", "/api.Storage/Scan"},
+	}))
+	defer srv.Close()
+
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := do(http.MethodPut, "/v1/keys/team-a/key", `{"value": "aGVsbG8="}`)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = do(http.MethodPut, "/v1/keys/team-b", `{"value": "d29ybGQ="}`)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/v1/keys/team-a/key", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	record := &api.Record{}
+	require.NoError(t, protojson.Unmarshal(b, record))
+	require.Equal(t, "team-a/key", record.Id)
+	require.Equal(t, []byte("hello"), record.Value)
+
+	resp = do(http.MethodGet, "/v1/keys?prefix=team-&limit=1", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	b, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	scan := &api.ScanResponse{}
+	require.NoError(t, protojson.Unmarshal(b, scan))
+	require.Len(t, scan.Records, 1)
+	require.Equal(t, "team-a/key", scan.Records[0].Id)
+
+	resp = do(http.MethodDelete, "/v1/keys/team-a/key", "")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = do(http.MethodGet, "/v1/keys/team-a/key", "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	var e struct{ Code, Message string }
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&e))
+	require.Equal(t, "NotFound", e.Code)
+
+	resp = do(http.MethodPut, "/v1/keys/_yass/acl", `{}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = do(http.MethodPut, "/v1/keys/key", `not json`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = do(http.MethodPost, "/v1/keys/key", "")
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestHTTPGatewayAuthorizes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gateway-test")
+	require.NoError(t, err)
+	db, err := kv.NewDB(dir, kv.Config{})
+	require.NoError(t, err)
+	defer db.Clear()
+
+	srv := httptest.NewServer(NewHTTPHandler(&Config{
+		DB:         localDB{db},
+		Authorizer: authorizer{"/api.Storage/Get"},
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodPut, srv.URL+"/v1/keys/key", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
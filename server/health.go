@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// healthMethodPrefix and reflectionMethodPrefix start the methods
+// every caller may use, whatever the Authorizer allows
+const (
+	healthMethodPrefix     = "/grpc.health.v1.Health/"
+	reflectionMethodPrefix = "/grpc.reflection.v1alpha.ServerReflection/"
+)
+
+// Readiness reports whether a node can serve requests
+type Readiness interface {
+	Ready() bool
+}
+
+var _ healthpb.HealthServer = (*healthServer)(nil)
+
+// healthServer reports every registered service as SERVING when
+// Config.Readiness is ready, or when there isn't one
+type healthServer struct {
+	*Config
+	services map[string]bool
+	interval time.Duration
+}
+
+func newhealthServer(config *Config, gsrv *grpc.Server) *healthServer {
+	services := map[string]bool{"": true}
+	for name := range gsrv.GetServiceInfo() {
+		services[name] = true
+	}
+	return &healthServer{Config: config, services: services, interval: time.Second}
+}
+
+func (s *healthServer) status(service string) (healthpb.HealthCheckResponse_ServingStatus, bool) {
+	if !s.services[service] {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, false
+	}
+	if s.Readiness != nil && !s.Readiness.Ready() {
+		return healthpb.HealthCheckResponse_NOT_SERVING, true
+	}
+	return healthpb.HealthCheckResponse_SERVING, true
+}
+
+func (s *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, ok := s.status(req.Service)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.Service)
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends the service's status and then every change to it
+func (s *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		if st, _ := s.status(req.Service); st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.Error(codes.Canceled, "stream has ended")
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+func TestHealthAndReflection(t *testing.T) {
+	ready := &readiness{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv, err := NewGRPCServer(&Config{
+		Authorizer: authorizer{"/api.Storage/Get"},
+		Readiness:  ready,
+		Reflection: true,
+		// probes aren't throttled however often they're made
+		RateLimits: &RateLimits{Client: Limit{Rate: 0.001, Burst: 1}},
+	})
+	require.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer cc.Close()
+
+	ctx := context.Background()
+	health := healthpb.NewHealthClient(cc)
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+
+	watch, err := health.Watch(ctx, &healthpb.HealthCheckRequest{Service: "api.Storage"})
+	require.NoError(t, err)
+	resp, err := watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	atomic.StoreInt32(&ready.ready, 1)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check("api.Admin"))
+	resp, err = watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	_, err = health.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	stream, err := rpb.NewServerReflectionClient(cc).ServerReflectionInfo(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	}))
+	info, err := stream.Recv()
+	require.NoError(t, err)
+	var services []string
+	for _, s := range info.GetListServicesResponse().Service {
+		services = append(services, s.Name)
+	}
+	require.Contains(t, services, "api.Storage")
+	require.Contains(t, services, "grpc.health.v1.Health")
+}
+
+type readiness struct {
+	ready int32
+}
+
+func (r *readiness) Ready() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Limit is a token bucket refilled at Rate tokens a second and
+// holding up to Burst tokens, Burst must be at least 1 when Rate is set
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimits throttles the calls of each client, identified by its
+// certificate subject. A zero Limit doesn't throttle
+type RateLimits struct {
+	// Client limits all the calls a client makes
+	Client Limit
+	// Methods limits a client's calls to each full method name,
+	// e.g. "/api.Storage/Set"
+	Methods map[string]Limit
+}
+
+func (r *RateLimits) validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.Client.Rate > 0 && r.Client.Burst < 1 {
+		return fmt.Errorf("the client rate limit needs a burst of at least 1")
+	}
+	for method, limit := range r.Methods {
+		if limit.Rate > 0 && limit.Burst < 1 {
+			return fmt.Errorf("the rate limit for %s needs a burst of at least 1", method)
+		}
+	}
+	return nil
+}
+
+// sweepInterval is how often the limiter drops the buckets
+// that have been idle long enough to refill
+const sweepInterval = time.Minute
+
+// limiter holds a token bucket per client, keyed by subject, and per
+// client and method, keyed by subject and method
+type limiter struct {
+	*RateLimits
+
+	mu      sync.Mutex
+	buckets map[[2]string]*bucket
+	swept   time.Time
+}
+
+// bucket is a token bucket and when a token was last taken from it
+type bucket struct {
+	*rate.Limiter
+	used time.Time
+}
+
+func newLimiter(limits *RateLimits) *limiter {
+	return &limiter{
+		RateLimits: limits,
+		buckets:    make(map[[2]string]*bucket),
+		swept:      time.Now(),
+	}
+}
+
+// allow takes a token from each of the client's buckets, returning
+// ResourceExhausted without taking any if one is empty
+func (l *limiter) allow(subject, method string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.swept) >= sweepInterval {
+		l.sweep(now)
+	}
+	var methodToken *rate.Reservation
+	if limit, ok := l.Methods[method]; ok && limit.Rate > 0 {
+		if methodToken = l.take([2]string{subject, method}, limit, now); methodToken == nil {
+			return status.Errorf(codes.ResourceExhausted, "%q is over the rate limit for %s", subject, method)
+		}
+	}
+	if l.Client.Rate > 0 {
+		if l.take([2]string{subject, ""}, l.Client, now) == nil {
+			if methodToken != nil {
+				methodToken.CancelAt(now)
+			}
+			return status.Errorf(codes.ResourceExhausted, "%q is over its rate limit", subject)
+		}
+	}
+	return nil
+}
+
+// take reserves a token from the bucket, returning nil
+// without taking one when it's empty
+func (l *limiter) take(key [2]string, limit Limit, now time.Time) *rate.Reservation {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{Limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		l.buckets[key] = b
+	}
+	b.used = now
+	r := b.ReserveN(now, 1)
+	if r.DelayFrom(now) > 0 {
+		r.CancelAt(now)
+		return nil
+	}
+	return r
+}
+
+// sweep drops the buckets that have been idle long enough to refill,
+// a new bucket is full so dropping them doesn't change any limit
+func (l *limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		refill := time.Duration(float64(b.Burst()) / float64(b.Limit()) * float64(time.Second))
+		if now.Sub(b.used) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// throttles returns the config's rate limiter and
+// the slots for the writes in flight
+func (c *Config) throttles() (*limiter, chan struct{}) {
+	c.throttleOnce.Do(func() {
+		if c.RateLimits != nil {
+			c.limiter = newLimiter(c.RateLimits)
+		}
+		if c.MaxInFlightApplies > 0 {
+			c.slots = make(chan struct{}, c.MaxInFlightApplies)
+		}
+	})
+	return c.limiter, c.slots
+}
+
+func rateLimitUnary(config *Config) grpc.UnaryServerInterceptor {
+	l, _ := config.throttles()
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		// health checks are exempt as authorization is, load balancer
+		// probes all share the empty subject
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(ctx, req)
+		}
+		if err := l.allow(subject(ctx), info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// admitUnary bounds the mutating calls in flight, and so the
+// entries queued for Raft, rejecting calls once it's reached
+func admitUnary(config *Config) grpc.UnaryServerInterceptor {
+	_, slots := config.throttles()
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if readOnlyMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		default:
+			return nil, status.Errorf(codes.ResourceExhausted, "%d writes are already in flight", config.MaxInFlightApplies)
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/michael-diggin/yass/api"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimits(t *testing.T) {
+	client, admin, teardown := setupTest(t, func(c *Config) {
+		c.RateLimits = &RateLimits{
+			Client: Limit{Rate: 0.001, Burst: 2},
+			Methods: map[string]Limit{
+				"/api.Storage/Set": {Rate: 0.001, Burst: 1},
+			},
+		}
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	set := func() error {
+		_, err := client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "key", Value: []byte("v")}})
+		return err
+	}
+	get := func() error {
+		_, err := client.Get(ctx, &api.GetRequest{Id: "key"})
+		return err
+	}
+
+	require.NoError(t, set())
+	require.Equal(t, codes.ResourceExhausted, status.Code(set()))
+	require.NoError(t, get())
+	require.Equal(t, codes.ResourceExhausted, status.Code(get()))
+
+	// other clients have their own buckets
+	_, err := admin.RaftStats(ctx, &api.RaftStatsRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestMaxInFlightApplies(t *testing.T) {
+	db := &blockingDB{applying: make(chan struct{}), release: make(chan struct{})}
+	client, _, teardown := setupTest(t, func(c *Config) {
+		c.DB = db
+		c.MaxInFlightApplies = 1
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	errc := make(chan error)
+	go func() {
+		_, err := client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "first"}})
+		errc <- err
+	}()
+	<-db.applying
+
+	_, err := client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "second"}})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	// reads aren't held back by writes
+	_, err = client.Get(ctx, &api.GetRequest{Id: "first"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	close(db.release)
+	require.NoError(t, <-errc)
+	_, err = client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "third"}})
+	require.NoError(t, err)
+}
+
+func TestLimiterDropsIdleBuckets(t *testing.T) {
+	l := newLimiter(&RateLimits{
+		Client:  Limit{Rate: 1, Burst: 1},
+		Methods: map[string]Limit{"/api.Storage/Set": {Rate: 0.01, Burst: 1}},
+	})
+	require.NoError(t, l.allow("a", "/api.Storage/Set"))
+	require.NoError(t, l.allow("b", "/api.Storage/Get"))
+	require.Len(t, l.buckets, 3)
+
+	// a's bucket for Set takes 100s to refill, the others a second
+	l.sweep(time.Now().Add(10 * time.Second))
+	require.Len(t, l.buckets, 1)
+	require.Contains(t, l.buckets, [2]string{"a", "/api.Storage/Set"})
+	require.Equal(t, codes.ResourceExhausted, status.Code(l.allow("a", "/api.Storage/Set")))
+}
+
+func TestRejectedCallsKeepTheirTokens(t *testing.T) {
+	l := newLimiter(&RateLimits{
+		Client:  Limit{Rate: 0.001, Burst: 1},
+		Methods: map[string]Limit{"/api.Storage/Set": {Rate: 0.001, Burst: 2}},
+	})
+	require.NoError(t, l.allow("a", "/api.Storage/Get"))
+	// rejected by the client bucket, the Set bucket keeps both tokens
+	require.Equal(t, codes.ResourceExhausted, status.Code(l.allow("a", "/api.Storage/Set")))
+	l.Client = Limit{}
+	require.NoError(t, l.allow("a", "/api.Storage/Set"))
+	require.NoError(t, l.allow("a", "/api.Storage/Set"))
+	require.Equal(t, codes.ResourceExhausted, status.Code(l.allow("a", "/api.Storage/Set")))
+}
+
+func TestRateLimitsNeedABurst(t *testing.T) {
+	for _, limits := range []*RateLimits{
+		{Client: Limit{Rate: 1}},
+		{Methods: map[string]Limit{"/api.Storage/Set": {Rate: 1}}},
+	} {
+		_, err := NewGRPCServer(&Config{RateLimits: limits})
+		require.Error(t, err)
+	}
+	_, err := NewGRPCServer(&Config{RateLimits: &RateLimits{Client: Limit{Burst: 0}}})
+	require.NoError(t, err)
+}
+
+func TestLimitsSharedByListeners(t *testing.T) {
+	config := &Config{
+		RateLimits:         &RateLimits{Client: Limit{Rate: 0.001, Burst: 1}},
+		MaxInFlightApplies: 1,
+	}
+	// gRPC, the HTTP gateway and the RESP server each build a chain
+	grpcChain := grpc_middleware.ChainUnaryServer(unaryInterceptors(config, zap.NewNop())...)
+	respChain := grpc_middleware.ChainUnaryServer(unaryInterceptors(config, zap.NewNop())...)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Storage/Set"}
+	applying, release := make(chan struct{}), make(chan struct{})
+	errc := make(chan error)
+	go func() {
+		_, err := grpcChain(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			close(applying)
+			<-release
+			return nil, nil
+		})
+		errc <- err
+	}()
+	<-applying
+
+	noop := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	_, err := respChain(context.Background(), nil, info, noop)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "rate limit")
+	close(release)
+	require.NoError(t, <-errc)
+
+	// writes in flight are bounded across the listeners too
+	config = &Config{MaxInFlightApplies: 1}
+	grpcChain = grpc_middleware.ChainUnaryServer(unaryInterceptors(config, zap.NewNop())...)
+	respChain = grpc_middleware.ChainUnaryServer(unaryInterceptors(config, zap.NewNop())...)
+	applying, release = make(chan struct{}), make(chan struct{})
+	go func() {
+		_, err := grpcChain(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			close(applying)
+			<-release
+			return nil, nil
+		})
+		errc <- err
+	}()
+	<-applying
+	_, err = respChain(context.Background(), nil, info, noop)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "in flight")
+	close(release)
+	require.NoError(t, <-errc)
+}
+
+// blockingDB holds each Set until release is closed
+type blockingDB struct {
+	applying chan struct{}
+	release  chan struct{}
+}
+
+func (db *blockingDB) Set(ctx context.Context, record *api.Record) (uint64, error) {
+	select {
+	case db.applying <- struct{}{}:
+	default:
+	}
+	<-db.release
+	return 0, nil
+}
+
+func (db *blockingDB) SetIf(ctx context.Context, record *api.Record, offset uint64) (uint64, error) {
+	return db.Set(ctx, record)
+}
+
+func (db *blockingDB) Get(ctx context.Context, id string) (*api.Record, error) {
+	return nil, api.ErrNotFound{Id: id}
+}
+
+func (db *blockingDB) GetAt(ctx context.Context, id string, offset uint64) (*api.Record, error) {
+	return nil, api.ErrNotFound{Id: id}
+}
+
+func (db *blockingDB) History(ctx context.Context, id string, limit int) ([]*api.Record, error) {
+	return nil, api.ErrNotFound{Id: id}
+}
+
+func (db *blockingDB) Scan(prefix string) []*api.Record {
+	return nil
+}
@@ -0,0 +1,570 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/resp"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ErrRESPServerClosed is returned by Serve once the server is closed
+var ErrRESPServerClosed = errors.New("server: RESP server closed")
+
+var errSyntax = status.Error(codes.InvalidArgument, "syntax error")
+
+// RESPServer serves keys in the default namespace over the Redis
+// protocol. Commands over several keys aren't atomic
+type RESPServer struct {
+	srv    *grpcServer
+	chain  grpc.UnaryServerInterceptor
+	logger *zap.Logger
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+func NewRESPServer(config *Config) *RESPServer {
+	logger := zap.L().Named("resp")
+	return &RESPServer{
+		srv:       &grpcServer{Config: config},
+		chain:     grpc_middleware.ChainUnaryServer(unaryInterceptors(config, logger)...),
+		logger:    logger,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections until the listener fails or
+// the server is closed
+func (s *RESPServer) Serve(ln net.Listener) error {
+	if !s.trackListener(ln) {
+		return ErrRESPServerClosed
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrRESPServerClosed
+			}
+			return err
+		}
+		if !s.trackConn(conn) {
+			conn.Close()
+			return ErrRESPServerClosed
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *RESPServer) trackListener(ln net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.listeners[ln] = struct{}{}
+	return true
+}
+
+func (s *RESPServer) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+// Close stops the listeners and closes every connection
+func (s *RESPServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *RESPServer) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	p := &peer.Peer{Addr: conn.RemoteAddr()}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			s.logger.Debug("tls handshake failed", zap.Error(err))
+			return
+		}
+		p.AuthInfo = credentials.TLSInfo{State: tlsConn.ConnectionState()}
+	}
+	ctx := peer.NewContext(context.Background(), p)
+
+	// no argument is larger than a key or a value
+	maxBulk := s.srv.maxKeyBytes()
+	if max := s.srv.maxValueBytes(); max > maxBulk {
+		maxBulk = max
+	}
+	r, w := resp.NewReader(conn, maxBulk), resp.NewWriter(conn)
+	for {
+		args, err := r.ReadCommand()
+		if err != nil {
+			if err != io.EOF {
+				w.Error("ERR " + err.Error())
+				w.Flush()
+			}
+			return
+		}
+		quit := s.exec(ctx, w, args)
+		if err := w.Flush(); err != nil || quit {
+			return
+		}
+	}
+}
+
+// exec runs a command and writes its reply,
+// it returns true when the client quits
+func (s *RESPServer) exec(ctx context.Context, w *resp.Writer, args [][]byte) bool {
+	name := strings.ToUpper(string(args[0]))
+	var err error
+	switch name {
+	case "PING":
+		if len(args) > 1 {
+			w.Bulk(args[1])
+		} else {
+			w.String("PONG")
+		}
+	case "QUIT":
+		w.String("OK")
+		return true
+	case "COMMAND":
+		w.Array(0)
+	case "GET":
+		err = s.cmdGet(ctx, w, args)
+	case "SET":
+		err = s.cmdSet(ctx, w, args)
+	case "DEL":
+		err = s.cmdDel(ctx, w, args)
+	case "EXISTS":
+		err = s.cmdExists(ctx, w, args)
+	case "EXPIRE":
+		err = s.cmdExpire(ctx, w, args)
+	case "MGET":
+		err = s.cmdMGet(ctx, w, args)
+	case "MSET":
+		err = s.cmdMSet(ctx, w, args)
+	case "SCAN":
+		err = s.cmdScan(ctx, w, args)
+	default:
+		w.Error("ERR unknown command '" + string(args[0]) + "'")
+	}
+	if err != nil {
+		writeRESPError(w, name, err)
+	}
+	return false
+}
+
+func (s *RESPServer) cmdGet(ctx context.Context, w *resp.Writer, args [][]byte) error {
+	if len(args) != 2 {
+		return errArity
+	}
+	record, err := s.get(ctx, string(args[1]))
+	if err != nil {
+		return err
+	}
+	w.Bulk(value(record))
+	return nil
+}
+
+// cmdSet handles SET key value [EX seconds|PX milliseconds]
+func (s *RESPServer) cmdSet(ctx context.Context, w *resp.Writer, args [][]byte) error {
+	if len(args) != 3 && len(args) != 5 {
+		return errArity
+	}
+	record := &api.Record{Id: string(args[1]), Value: args[2]}
+	if len(args) == 5 {
+		var unit time.Duration
+		switch strings.ToUpper(string(args[3])) {
+		case "EX":
+			unit = time.Second
+		case "PX":
+			unit = time.Millisecond
+		default:
+			return errSyntax
+		}
+		n, err := strconv.ParseInt(string(args[4]), 10, 64)
+		if err != nil || n <= 0 {
+			return errExpireTime
+		}
+		if record.ExpiresAt, err = expiresAt(n, unit); err != nil {
+			return err
+		}
+	}
+	if err := s.set(ctx, record); err != nil {
+		return err
+	}
+	w.String("OK")
+	return nil
+}
+
+func (s *RESPServer) cmdDel(ctx context.Context, w *resp.Writer, args [][]byte) error {
+	if len(args) < 2 {
+		return errArity
+	}
+	var n int64
+	for _, key := range args[1:] {
+		deleted, err := s.update(ctx, string(key), func(record *api.Record) *api.Record {
+			return &api.Record{Id: record.Id, Deleted: true}
+		})
+		if err != nil {
+			return err
+		}
+		if deleted {
+			n++
+		}
+	}
+	w.Integer(n)
+	return nil
+}
+
+func (s *RESPServer) cmdExists(ctx context.Context, w *resp.Writer, args [][]byte) error {
+	if len(args) < 2 {
+		return errArity
+	}
+	var n int64
+	for _, key := range args[1:] {
+		record, err := s.get(ctx, string(key))
+		if err != nil {
+			return err
+		}
+		if record != nil {
+			n++
+		}
+	}
+	w.Integer(n)
+	return nil
+}
+
+// cmdExpire handles EXPIRE key seconds, the key is
+// deleted when seconds isn't positive
+func (s *RESPServer) cmdExpire(ctx context.Context, w *resp.Writer, args [][]byte) error {
+	if len(args) != 3 {
+		return errArity
+	}
+	n, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "value is not an integer or out of range")
+	}
+	var at int64
+	if n > 0 {
+		if at, err = expiresAt(n, time.Second); err != nil {
+			return err
+		}
+	}
+	updated, err := s.update(ctx, string(args[1]), func(record *api.Record) *api.Record {
+		if n <= 0 {
+			return &api.Record{Id: record.Id, Deleted: true}
+		}
+		return &api.Record{Id: record.Id, Value: record.Value, ExpiresAt: at}
+	})
+	if err != nil {
+		return err
+	}
+	if updated {
+		w.Integer(1)
+	} else {
+		w.Integer(0)
+	}
+	return nil
+}
+
+var errExpireTime = status.Error(codes.InvalidArgument, "invalid expire time")
+
+// expiresAt returns when a key given a ttl of n units expires,
+// rejecting ttls past the largest time a record can hold
+func expiresAt(n int64, unit time.Duration) (int64, error) {
+	now := time.Now().UnixNano()
+	if n > (math.MaxInt64-now)/int64(unit) {
+		return 0, errExpireTime
+	}
+	return now + n*int64(unit), nil
+}
+
+func (s *RESPServer) cmdMGet(ctx context.Context, w *resp.Writer, args [][]byte) error {
+	if len(args) < 2 {
+		return errArity
+	}
+	values := make([][]byte, 0, len(args)-1)
+	for _, key := range args[1:] {
+		record, err := s.get(ctx, string(key))
+		if err != nil {
+			return err
+		}
+		values = append(values, value(record))
+	}
+	w.Array(len(values))
+	for _, v := range values {
+		w.Bulk(v)
+	}
+	return nil
+}
+
+func (s *RESPServer) cmdMSet(ctx context.Context, w *resp.Writer, args [][]byte) error {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return errArity
+	}
+	for i := 1; i < len(args); i += 2 {
+		if err := s.set(ctx, &api.Record{Id: string(args[i]), Value: args[i+1]}); err != nil {
+			return err
+		}
+	}
+	w.String("OK")
+	return nil
+}
+
+// cmdScan handles SCAN cursor [MATCH pattern] [COUNT count]. The
+// cursor is the position in the sorted matching keys to carry on from
+func (s *RESPServer) cmdScan(ctx context.Context, w *resp.Writer, args [][]byte) error {
+	if len(args) < 2 || len(args)%2 == 1 {
+		return errArity
+	}
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "invalid cursor")
+	}
+	pattern, count := "*", uint64(10)
+	for i := 2; i < len(args); i += 2 {
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			count, err = strconv.ParseUint(string(args[i+1]), 10, 64)
+			if err != nil || count == 0 {
+				return errSyntax
+			}
+		default:
+			return errSyntax
+		}
+	}
+
+	scan, err := s.call(ctx, "/api.Storage/Scan", &api.ScanRequest{Prefix: globPrefix(pattern)},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return s.srv.Scan(ctx, req.(*api.ScanRequest))
+		})
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for _, record := range scan.(*api.ScanResponse).Records {
+		if globMatch(pattern, record.Id) {
+			keys = append(keys, record.Id)
+		}
+	}
+	next := uint64(0)
+	if cursor >= uint64(len(keys)) {
+		keys = nil
+	} else {
+		keys = keys[cursor:]
+		if uint64(len(keys)) > count {
+			keys = keys[:count]
+			next = cursor + count
+		}
+	}
+
+	w.Array(2)
+	w.Bulk([]byte(strconv.FormatUint(next, 10)))
+	w.Array(len(keys))
+	for _, key := range keys {
+		w.Bulk([]byte(key))
+	}
+	return nil
+}
+
+// get returns the key's record, or nil if it isn't found
+func (s *RESPServer) get(ctx context.Context, key string) (*api.Record, error) {
+	resp, err := s.call(ctx, "/api.Storage/Get", &api.GetRequest{Id: key},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return s.srv.Get(ctx, req.(*api.GetRequest))
+		})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*api.GetResponse).Record, nil
+}
+
+func (s *RESPServer) set(ctx context.Context, record *api.Record) error {
+	return s.setRequest(ctx, &api.SetRequest{Record: record})
+}
+
+func (s *RESPServer) setRequest(ctx context.Context, req *api.SetRequest) error {
+	_, err := s.call(ctx, "/api.Storage/Set", req,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return s.srv.Set(ctx, req.(*api.SetRequest))
+		})
+	return err
+}
+
+// update replaces the key's record with the one fn returns for it,
+// only while the key is unchanged since it was read. It returns
+// false when there's no record
+func (s *RESPServer) update(ctx context.Context, key string, fn func(*api.Record) *api.Record) (bool, error) {
+	for {
+		record, err := s.get(ctx, key)
+		if err != nil || record == nil {
+			return false, err
+		}
+		err = s.setRequest(ctx, &api.SetRequest{
+			Record:    fn(record),
+			Condition: &api.Condition{Offset: record.Offset},
+		})
+		if status.Code(err) == codes.Aborted {
+			continue
+		}
+		return err == nil, err
+	}
+}
+
+func (s *RESPServer) call(ctx context.Context, method string, req interface{}, handler grpc.UnaryHandler) (interface{}, error) {
+	return s.chain(ctx, req, &grpc.UnaryServerInfo{Server: s.srv, FullMethod: method}, handler)
+}
+
+// errArity is reported with the command's name by writeRESPError
+var errArity = errors.New("wrong number of arguments")
+
+func writeRESPError(w *resp.Writer, name string, err error) {
+	if err == errArity {
+		w.Error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return
+	}
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.PermissionDenied:
+		w.Error("NOPERM " + st.Message())
+	default:
+		w.Error("ERR " + st.Message())
+	}
+}
+
+// value returns a record's value as a bulk string,
+// which is null when there's no record
+func value(record *api.Record) []byte {
+	if record == nil {
+		return nil
+	}
+	if record.Value == nil {
+		return []byte{}
+	}
+	return record.Value
+}
+
+// globPrefix returns the literal start of a glob pattern
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// globMatch reports whether name matches a Redis glob pattern,
+// where '*' and '?' also match '/', unlike path.Match
+func globMatch(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if globMatch(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if name == "" {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		case '[':
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 || name == "" {
+				return false
+			}
+			class := pattern[1 : end+1]
+			negate := strings.HasPrefix(class, "^")
+			if negate {
+				class = class[1:]
+			}
+			if matchClass(class, name[0]) == negate {
+				return false
+			}
+			pattern, name = pattern[end+2:], name[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if name == "" || pattern[0] != name[0] {
+				return false
+			}
+			pattern, name = pattern[1:], name[1:]
+		}
+	}
+	return name == ""
+}
+
+func matchClass(class string, c byte) bool {
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			if class[i] <= c && c <= class[i+2] {
+				return true
+			}
+			i += 2
+			continue
+		}
+		if class[i] == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michael-diggin/yass/kv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRESPServer(t *testing.T) {
+	do, teardown := setupRESPTest(t, authorizer{"/api.Storage/Get", "/api.Storage/Set", "/api.Storage/Scan"})
+	defer teardown()
+
+	require.Equal(t, "PONG", do("PING"))
+	require.Equal(t, "OK", do("SET", "a/1", "one"))
+	require.Equal(t, "one", do("GET", "a/1"))
+	require.Nil(t, do("GET", "missing"))
+	require.Equal(t, "OK", do("MSET", "a/2", "two", "b/1", ""))
+	require.Equal(t, []interface{}{"one", nil, ""}, do("MGET", "a/1", "missing", "b/1"))
+	require.Equal(t, int64(2), do("EXISTS", "a/1", "b/1", "missing"))
+
+	require.Equal(t, []interface{}{"2", []interface{}{"a/1", "a/2"}}, do("SCAN", "0", "COUNT", "2"))
+	require.Equal(t, []interface{}{"0", []interface{}{"b/1"}}, do("SCAN", "2", "COUNT", "2"))
+	require.Equal(t, []interface{}{"0", []interface{}{"a/2"}}, do("SCAN", "0", "MATCH", "a/[2-9]*"))
+
+	require.Equal(t, int64(2), do("DEL", "a/2", "b/1", "missing"))
+	require.Nil(t, do("GET", "a/2"))
+
+	require.Equal(t, int64(1), do("EXPIRE", "a/1", "100"))
+	require.Equal(t, "one", do("GET", "a/1"))
+	require.Equal(t, int64(0), do("EXPIRE", "missing", "100"))
+	require.Equal(t, respError("ERR invalid expire time"), do("EXPIRE", "a/1", "9223372036854775807"))
+	require.Equal(t, respError("ERR invalid expire time"), do("SET", "a/1", "one", "EX", "9223372037"))
+	require.Equal(t, "one", do("GET", "a/1"))
+	require.Equal(t, "OK", do("SET", "short", "lived", "PX", "50"))
+	require.Equal(t, "lived", do("GET", "short"))
+	require.Eventually(t, func() bool {
+		return do("GET", "short") == nil
+	}, time.Second, 20*time.Millisecond)
+
+	require.Equal(t, respError("ERR wrong number of arguments for 'get' command"), do("GET"))
+	require.Equal(t, respError("ERR syntax error"), do("SET", "k", "v", "XX", "1"))
+	require.Equal(t, respError("ERR unknown command 'HSET'"), do("HSET", "k", "f", "v"))
+	require.True(t, strings.HasPrefix(string(do("SET", "_yass/acl", "v").(respError)), "ERR "))
+	require.Equal(t, "OK", do("QUIT"))
+}
+
+func TestRESPServerAuthorizes(t *testing.T) {
+	do, teardown := setupRESPTest(t, authorizer{"/api.Storage/Get"})
+	defer teardown()
+
+	require.Nil(t, do("GET", "key"))
+	require.True(t, strings.HasPrefix(string(do("SET", "key", "v").(respError)), "NOPERM "))
+}
+
+func TestRESPServerRejectsOversizedCommands(t *testing.T) {
+	srv := NewRESPServer(&Config{MaxKeyBytes: 8, MaxValueBytes: 16})
+	defer srv.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(ln)
+
+	for _, command := range []string{
+		"*1099511627776\r\n",
+		"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$17\r\n",
+	} {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		_, err = conn.Write([]byte(command))
+		require.NoError(t, err)
+		r := bufio.NewReader(conn)
+		require.Equal(t, respError("ERR protocol error"), readReply(t, r))
+		_, err = r.ReadByte()
+		require.Equal(t, io.EOF, err)
+		conn.Close()
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	for pattern, names := range map[string][2][]string{
+		"*":        {{"", "a", "a/b"}, nil},
+		"a/*":      {{"a/", "a/b/c"}, {"a", "b/a"}},
+		"h?llo":    {{"hello", "hallo"}, {"hllo", "heello"}},
+		"h[ae]llo": {{"hello", "hallo"}, {"hillo"}},
+		"h[^e]llo": {{"hallo"}, {"hello"}},
+		"h[a-c]y":  {{"hby"}, {"hdy"}},
+		`a\*`:      {{"a*"}, {"ab"}},
+	} {
+		for _, name := range names[0] {
+			require.True(t, globMatch(pattern, name), "%q %q", pattern, name)
+		}
+		for _, name := range names[1] {
+			require.False(t, globMatch(pattern, name), "%q %q", pattern, name)
+		}
+	}
+}
+
+type respError string
+
+func setupRESPTest(t *testing.T, authorizer Authorizer) (func(args ...string) interface{}, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "resp-test")
+	require.NoError(t, err)
+	db, err := kv.NewDB(dir, kv.Config{})
+	require.NoError(t, err)
+
+	srv := NewRESPServer(&Config{DB: localDB{db}, Authorizer: authorizer})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	r := bufio.NewReader(conn)
+	do := func(args ...string) interface{} {
+		fmt.Fprintf(conn, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+		return readReply(t, r)
+	}
+	return do, func() {
+		conn.Close()
+		srv.Close()
+		db.Clear()
+	}
+}
+
+// readReply returns a reply as a string, respError,
+// int64, nil or []interface{} of replies
+func readReply(t *testing.T, r *bufio.Reader) interface{} {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return respError(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		require.NoError(t, err)
+		return n
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		require.NoError(t, err)
+		if n < 0 {
+			return nil
+		}
+		b := make([]byte, n+2)
+		_, err = io.ReadFull(r, b)
+		require.NoError(t, err)
+		return string(b[:n])
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		require.NoError(t, err)
+		replies := make([]interface{}, n)
+		for i := range replies {
+			replies[i] = readReply(t, r)
+		}
+		return replies
+	}
+	t.Fatalf("unexpected reply %q", line)
+	return nil
+}
@@ -2,24 +2,135 @@ package server
 
 import (
 	"context"
+	"strings"
+	"sync"
 	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
 	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/kv"
+	"github.com/michael-diggin/yass/tracing"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 type Config struct {
-	DB DB
+	DB         DB
+	Cluster    Cluster
+	Membership Membership
+	// GossipKeys are managed through the admin service when set
+	GossipKeys GossipKeys
+	// Authorizer checks the caller's certificate subject before every
+	// call. Without one only the storage service may be called
+	Authorizer Authorizer
+	// ACLs restricts which keys each certificate subject may read
+	// and write. Without them any key may be accessed
+	ACLs ACLs
+	// Namespaces are managed through the admin service when set
+	Namespaces Namespaces
+	// Bulk serves the admin service's Export and Import when set
+	Bulk Bulk
+	// Auditor records every mutating call when set
+	Auditor Auditor
+	// RateLimits throttles each client when set, calls over
+	// a limit get ResourceExhausted
+	RateLimits *RateLimits
+	// MaxInFlightApplies bounds the mutating calls being applied
+	// through Raft at once, it is unbounded when zero
+	MaxInFlightApplies int
+	// MaxKeyBytes and MaxValueBytes bound the records clients
+	// may set, they default to 1KB and 1MB
+	MaxKeyBytes   int
+	MaxValueBytes int
+	// Readiness decides whether the health service reports SERVING,
+	// it always does when unset
+	Readiness Readiness
+	// Reflection registers the server reflection service, for
+	// tools like grpcurl
+	Reflection bool
+	// GRPCMetrics records per-method latency and error counts when set
+	GRPCMetrics *grpc_prometheus.ServerMetrics
+
+	// throttleOnce builds the limiter and slots once, so the
+	// limits hold across every listener serving the config
+	throttleOnce sync.Once
+	limiter      *limiter
+	slots        chan struct{}
 }
 
 type DB interface {
-	Set(record *api.Record) error
-	Get(id string) (*api.Record, error)
+	// Set and SetIf return the Raft index the record was applied at,
+	// SetIf sets it only while its key's latest version is at the offset
+	Set(ctx context.Context, record *api.Record) (uint64, error)
+	SetIf(ctx context.Context, record *api.Record, offset uint64) (uint64, error)
+	Get(ctx context.Context, id string) (*api.Record, error)
+	// GetAt returns the version of a key that was current at an
+	// offset, History the key's versions newest first
+	GetAt(ctx context.Context, id string, offset uint64) (*api.Record, error)
+	History(ctx context.Context, id string, limit int) ([]*api.Record, error)
+	// Scan returns the records in the default namespace whose
+	// keys start with prefix, sorted by key
+	Scan(prefix string) []*api.Record
+}
+
+// readOnlyMethods are the methods that don't change any state,
+// they aren't audited or counted as writes in flight
+var readOnlyMethods = map[string]bool{
+	"/api.Storage/Get":           true,
+	"/api.Storage/Scan":          true,
+	"/api.Storage/GetHistory":    true,
+	"/api.Admin/RaftStats":       true,
+	"/api.Admin/ListMembers":     true,
+	"/api.Admin/ListACLs":        true,
+	"/api.Admin/ListNamespaces":  true,
+	"/api.Admin/ListGossipKeys":  true,
+	"/api.Admin/Backup":          true,
+	healthMethodPrefix + "Check": true,
+}
+
+var zapOptions = []grpc_zap.Option{
+	grpc_zap.WithDurationField(func(duration time.Duration) zapcore.Field {
+		return zap.Int64("grcp.time_ns", duration.Nanoseconds())
+	}),
+}
+
+// unaryInterceptors are run on every unary call, in order, whether it
+// came over gRPC, through the HTTP gateway or the RESP server
+func unaryInterceptors(config *Config, logger *zap.Logger) []grpc.UnaryServerInterceptor {
+	interceptors := []grpc.UnaryServerInterceptor{
+		grpc_ctxtags.UnaryServerInterceptor(),
+		tracing.UnaryServerInterceptor(),
+		grpc_zap.UnaryServerInterceptor(logger, zapOptions...),
+	}
+	if config.GRPCMetrics != nil {
+		interceptors = append(interceptors, config.GRPCMetrics.UnaryServerInterceptor())
+	}
+	return append(interceptors, callInterceptors(config)...)
+}
+
+// callInterceptors audit, authorize and throttle a call. They're run
+// on every unary call and on each batch of an Import stream
+func callInterceptors(config *Config) []grpc.UnaryServerInterceptor {
+	var interceptors []grpc.UnaryServerInterceptor
+	if config.Auditor != nil {
+		interceptors = append(interceptors, auditUnary(config))
+	}
+	interceptors = append(interceptors, authorizeUnary(config))
+	if config.RateLimits != nil {
+		interceptors = append(interceptors, rateLimitUnary(config))
+	}
+	if config.MaxInFlightApplies > 0 {
+		interceptors = append(interceptors, admitUnary(config))
+	}
+	return interceptors
 }
 
 var _ api.StorageServer = (*grpcServer)(nil)
@@ -34,21 +145,23 @@ func newgrpcServer(config *Config) (*grpcServer, error) {
 }
 
 func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	if err := config.RateLimits.validate(); err != nil {
+		return nil, err
+	}
 	logger := zap.L().Named("server")
-	zapOpts := []grpc_zap.Option{
-		grpc_zap.WithDurationField(func(duration time.Duration) zapcore.Field {
-			return zap.Int64("grcp.time_ns", duration.Nanoseconds())
-		}),
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		grpc_ctxtags.StreamServerInterceptor(),
+		tracing.StreamServerInterceptor(),
+		grpc_zap.StreamServerInterceptor(logger, zapOptions...),
+	}
+	if config.GRPCMetrics != nil {
+		streamInterceptors = append(streamInterceptors, config.GRPCMetrics.StreamServerInterceptor())
 	}
+	streamInterceptors = append(streamInterceptors, authorizeStream(config))
 
-	opts = append(opts, grpc.StreamInterceptor(
-		grpc_middleware.ChainStreamServer(
-			grpc_ctxtags.StreamServerInterceptor(),
-			grpc_zap.StreamServerInterceptor(logger, zapOpts...),
-		)), grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-		grpc_ctxtags.UnaryServerInterceptor(),
-		grpc_zap.UnaryServerInterceptor(logger, zapOpts...),
-	)),
+	opts = append(opts,
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors(config, logger)...)),
 	)
 	gsrv := grpc.NewServer(opts...)
 	srv, err := newgrpcServer(config)
@@ -56,11 +169,36 @@ func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, err
 		return nil, err
 	}
 	api.RegisterStorageServer(gsrv, srv)
+	adminSrv, err := newadminServer(config)
+	if err != nil {
+		return nil, err
+	}
+	api.RegisterAdminServer(gsrv, adminSrv)
+	if config.Reflection {
+		reflection.Register(gsrv)
+	}
+	healthpb.RegisterHealthServer(gsrv, newhealthServer(config, gsrv))
+	if config.GRPCMetrics != nil {
+		config.GRPCMetrics.InitializeMetrics(gsrv)
+	}
 	return gsrv, nil
 }
 
 func (s *grpcServer) Set(ctx context.Context, req *api.SetRequest) (*api.SetResponse, error) {
-	err := s.DB.Set(req.Record)
+	if err := s.validateRecord(req.Record); err != nil {
+		return nil, err
+	}
+	if err := s.checkKey(ctx, req.Record.GetNamespace(), req.Record.GetId(), true); err != nil {
+		return nil, err
+	}
+	var index uint64
+	var err error
+	if req.Condition != nil {
+		index, err = s.DB.SetIf(ctx, req.Record, req.Condition.Offset)
+	} else {
+		index, err = s.DB.Set(ctx, req.Record)
+	}
+	setIndex(ctx, index)
 	if err != nil {
 		return nil, err
 	}
@@ -68,9 +206,86 @@ func (s *grpcServer) Set(ctx context.Context, req *api.SetRequest) (*api.SetResp
 }
 
 func (s *grpcServer) Get(ctx context.Context, req *api.GetRequest) (*api.GetResponse, error) {
-	rec, err := s.DB.Get(req.Id)
+	if err := s.validateKey(req.Namespace, req.Id); err != nil {
+		return nil, err
+	}
+	if err := s.checkKey(ctx, req.Namespace, req.Id, false); err != nil {
+		return nil, err
+	}
+	var rec *api.Record
+	var err error
+	if req.AsOf != nil {
+		rec, err = s.DB.GetAt(ctx, kv.Key(req.Namespace, req.Id), req.AsOf.Offset)
+	} else {
+		rec, err = s.DB.Get(ctx, kv.Key(req.Namespace, req.Id))
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &api.GetResponse{Record: rec}, nil
 }
+
+// GetHistory returns the versions of a key newest first,
+// up to the limit when it's positive
+func (s *grpcServer) GetHistory(ctx context.Context, req *api.GetHistoryRequest) (*api.GetHistoryResponse, error) {
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit is negative")
+	}
+	if err := s.validateKey(req.Namespace, req.Id); err != nil {
+		return nil, err
+	}
+	if err := s.checkKey(ctx, req.Namespace, req.Id, false); err != nil {
+		return nil, err
+	}
+	records, err := s.DB.History(ctx, kv.Key(req.Namespace, req.Id), int(req.Limit))
+	if err != nil {
+		return nil, err
+	}
+	return &api.GetHistoryResponse{Records: records}, nil
+}
+
+// Scan returns the keys starting with the prefix that the caller
+// may read, up to the limit when it's positive
+func (s *grpcServer) Scan(ctx context.Context, req *api.ScanRequest) (*api.ScanResponse, error) {
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit is negative")
+	}
+	if strings.HasPrefix(req.Prefix, kv.ReservedPrefix) {
+		return nil, status.Errorf(codes.InvalidArgument, "keys starting with %q are reserved", kv.ReservedPrefix)
+	}
+	resp := &api.ScanResponse{}
+	for _, record := range s.DB.Scan(req.Prefix) {
+		if strings.HasPrefix(record.Id, kv.ReservedPrefix) {
+			continue
+		}
+		if s.checkKey(ctx, "", record.Id, false) != nil {
+			continue
+		}
+		resp.Records = append(resp.Records, record)
+		if req.Limit > 0 && len(resp.Records) == int(req.Limit) {
+			break
+		}
+	}
+	return resp, nil
+}
+
+// checkKey returns a PermissionDenied error if the ACLs
+// don't grant the caller access to the key.
+// Keys in a namespace are matched against ACLs as "<namespace>/<id>"
+func (c *Config) checkKey(ctx context.Context, namespace, id string, write bool) error {
+	if c.ACLs == nil {
+		return nil
+	}
+	if namespace != "" {
+		id = namespace + "/" + id
+	}
+	sub := subject(ctx)
+	if !c.ACLs.Allowed(sub, id, write) {
+		access := "read"
+		if write {
+			access = "write"
+		}
+		return status.Errorf(codes.PermissionDenied, "%q is not permitted to %s %q", sub, access, id)
+	}
+	return nil
+}
@@ -4,19 +4,22 @@ import (
 	"context"
 	"io/ioutil"
 	"net"
+	"path/filepath"
 	"testing"
 
 	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/auth"
 	"github.com/michael-diggin/yass/config"
 	"github.com/michael-diggin/yass/kv"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 func TestSetAndGetFromServer(t *testing.T) {
-	client, teardown := setupTest(t)
+	client, _, teardown := setupTest(t, nil)
 	defer teardown()
 
 	ctx := context.Background()
@@ -29,8 +32,40 @@ func TestSetAndGetFromServer(t *testing.T) {
 	require.Equal(t, setRec.Value, resp.Record.Value)
 }
 
+func TestConditionalSet(t *testing.T) {
+	client, _, teardown := setupTest(t, nil)
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "key", Value: []byte("v1")}})
+	require.NoError(t, err)
+	resp, err := client.Get(ctx, &api.GetRequest{Id: "key"})
+	require.NoError(t, err)
+	offset := resp.Record.Offset
+
+	_, err = client.Set(ctx, &api.SetRequest{
+		Record:    &api.Record{Id: "key", Value: []byte("v2")},
+		Condition: &api.Condition{Offset: offset},
+	})
+	require.NoError(t, err)
+	_, err = client.Set(ctx, &api.SetRequest{
+		Record:    &api.Record{Id: "key", Value: []byte("v3")},
+		Condition: &api.Condition{Offset: offset},
+	})
+	require.Equal(t, codes.Aborted, status.Code(err))
+	_, err = client.Set(ctx, &api.SetRequest{
+		Record:    &api.Record{Id: "missing", Value: []byte("v")},
+		Condition: &api.Condition{Offset: offset},
+	})
+	require.Equal(t, codes.Aborted, status.Code(err))
+
+	resp, err = client.Get(ctx, &api.GetRequest{Id: "key"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2"), resp.Record.Value)
+}
+
 func TestGetNotFoundFromServer(t *testing.T) {
-	client, teardown := setupTest(t)
+	client, _, teardown := setupTest(t, nil)
 	defer teardown()
 
 	ctx := context.Background()
@@ -41,23 +76,102 @@ func TestGetNotFoundFromServer(t *testing.T) {
 	require.Equal(t, codes.NotFound, grpc.Code(err))
 }
 
-func setupTest(t *testing.T) (api.StorageClient, func()) {
-	t.Helper()
+func TestGetHistoryFromServer(t *testing.T) {
+	client, _, teardown := setupTest(t, nil)
+	defer teardown()
 
-	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ctx := context.Background()
+	var offsets []uint64
+	for _, value := range []string{"v1", "v2", "v3"} {
+		_, err := client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "config", Value: []byte(value)}})
+		require.NoError(t, err)
+		resp, err := client.Get(ctx, &api.GetRequest{Id: "config"})
+		require.NoError(t, err)
+		offsets = append(offsets, resp.Record.Offset)
+	}
+
+	history, err := client.GetHistory(ctx, &api.GetHistoryRequest{Id: "config"})
 	require.NoError(t, err)
+	require.Len(t, history.Records, 3)
+	require.Equal(t, []byte("v3"), history.Records[0].Value)
+	require.Equal(t, offsets[1], history.Records[0].PrevOffset)
+	require.False(t, history.Records[2].HasPrev)
 
-	clientTLSConfig, err := config.SetUpTLSConfig(config.TLSConfig{
-		CAFile:   config.CAFile,
-		CertFile: config.ClientCertFile,
-		KeyFile:  config.ClientKeyFile,
-	})
+	history, err = client.GetHistory(ctx, &api.GetHistoryRequest{Id: "config", Limit: 1})
 	require.NoError(t, err)
-	clientCreds := credentials.NewTLS(clientTLSConfig)
+	require.Len(t, history.Records, 1)
+
+	resp, err := client.Get(ctx, &api.GetRequest{Id: "config", AsOf: &api.AsOf{Offset: offsets[1]}})
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2"), resp.Record.Value)
+
+	_, err = client.GetHistory(ctx, &api.GetHistoryRequest{Id: "missing"})
+	require.Equal(t, codes.NotFound, grpc.Code(err))
+	_, err = client.GetHistory(ctx, &api.GetHistoryRequest{Id: "config", Limit: -1})
+	require.Equal(t, codes.InvalidArgument, grpc.Code(err))
+}
+
+const testPolicy = `{
+	"roles": {
+		"reader": ["/api.Storage/Get"],
+		"storage": ["/api.Storage/*"],
+		"admin": ["/api.Admin/*"]
+	},
+	"subjects": {
+		"client": ["storage"],
+		"admin": ["admin", "storage"],
+		"*": ["reader"]
+	}
+}`
 
-	cc, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(clientCreds))
+func TestUnauthorizedSet(t *testing.T) {
+	client, _, teardown := setupTest(t, func(c *Config) {
+		c.Authorizer = authorizer{"/api.Storage/Get"}
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	setRec := &api.Record{Id: "test-key", Value: []byte("hello world")}
+	_, err := client.Set(ctx, &api.SetRequest{Record: setRec})
+	require.Equal(t, codes.PermissionDenied, grpc.Code(err))
+
+	_, err = client.Get(ctx, &api.GetRequest{Id: setRec.Id})
+	require.Equal(t, codes.NotFound, grpc.Code(err))
+}
+
+// authorizer allows any subject to call only the given methods
+type authorizer []string
+
+func (a authorizer) Authorize(subject, method string) error {
+	for _, allowed := range a {
+		if allowed == method {
+			return nil
+		}
+	}
+	return status.Errorf(codes.PermissionDenied, "%s: %s", subject, method)
+}
+
+func setupTest(t *testing.T, fn func(*Config)) (api.StorageClient, api.AdminClient, func()) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
 
+	newClient := func(certFile, keyFile string) *grpc.ClientConn {
+		tlsConfig, err := config.SetUpTLSConfig(config.TLSConfig{
+			CAFile:   config.CAFile,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		})
+		require.NoError(t, err)
+		creds := credentials.NewTLS(tlsConfig)
+		cc, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(creds))
+		require.NoError(t, err)
+		return cc
+	}
+	cc := newClient(config.ClientCertFile, config.ClientKeyFile)
+	adminCC := newClient(config.AdminCertFile, config.AdminKeyFile)
+
 	serverTLSConfig, err := config.SetUpTLSConfig(config.TLSConfig{
 		CAFile:        config.CAFile,
 		CertFile:      config.ServerCertFile,
@@ -73,18 +187,40 @@ func setupTest(t *testing.T) (api.StorageClient, func()) {
 	db, err := kv.NewDB(dir, kv.Config{})
 	require.NoError(t, err)
 
-	cfg := &Config{DB: db}
+	policyFile := filepath.Join(dir, "policy.json")
+	require.NoError(t, ioutil.WriteFile(policyFile, []byte(testPolicy), 0644))
+	authorizer, err := auth.New(auth.Config{PolicyFile: policyFile, ReloadInterval: -1})
+	require.NoError(t, err)
+
+	cfg := &Config{DB: localDB{db}, Authorizer: authorizer}
+	if fn != nil {
+		fn(cfg)
+	}
 	server, err := NewGRPCServer(cfg, grpc.Creds(serverCreds))
 	require.NoError(t, err)
 	go func() {
 		server.Serve(l)
 	}()
 
-	return api.NewStorageClient(cc), func() {
+	return api.NewStorageClient(cc), api.NewAdminClient(adminCC), func() {
 		server.Stop()
 		cc.Close()
+		adminCC.Close()
 		l.Close()
 		db.Clear()
 	}
 
 }
+
+// localDB serves a kv.DB without Raft, so writes have no Raft index
+type localDB struct {
+	*kv.DB
+}
+
+func (db localDB) Set(ctx context.Context, record *api.Record) (uint64, error) {
+	return 0, db.DB.Set(ctx, record)
+}
+
+func (db localDB) SetIf(ctx context.Context, record *api.Record, offset uint64) (uint64, error) {
+	return 0, db.DB.SetIf(ctx, record, offset)
+}
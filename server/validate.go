@@ -0,0 +1,72 @@
+package server
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/michael-diggin/yass/kv"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultMaxKeyBytes   = 1 << 10
+	defaultMaxValueBytes = 1 << 20
+)
+
+// validateKey returns an InvalidArgument error unless the id is
+// non-empty UTF-8 no longer than MaxKeyBytes, free of NUL bytes and
+// outside the reserved prefix, and the namespace is a valid name
+func (c *Config) validateKey(namespace, id string) error {
+	max := c.maxKeyBytes()
+	switch {
+	case id == "":
+		return status.Error(codes.InvalidArgument, "key is empty")
+	case len(id) > max:
+		return status.Errorf(codes.InvalidArgument, "key is %d bytes, over the maximum of %d", len(id), max)
+	case !utf8.ValidString(id):
+		return status.Error(codes.InvalidArgument, "key is not valid UTF-8")
+	case strings.IndexByte(id, 0) >= 0:
+		return status.Error(codes.InvalidArgument, "key contains a NUL byte")
+	case strings.HasPrefix(id, kv.ReservedPrefix):
+		return status.Errorf(codes.InvalidArgument, "keys starting with %q are reserved", kv.ReservedPrefix)
+	case namespace != "" && !validNamespace(namespace):
+		return status.Errorf(codes.InvalidArgument, "invalid namespace name %q", namespace)
+	}
+	return nil
+}
+
+// validateRecord checks a client's record before it is applied,
+// fields only the cluster sets must be left empty
+func (c *Config) validateRecord(record *api.Record) error {
+	if record == nil {
+		return status.Error(codes.InvalidArgument, "record is missing")
+	}
+	if err := c.validateKey(record.Namespace, record.Id); err != nil {
+		return err
+	}
+	max := c.maxValueBytes()
+	if len(record.Value) > max {
+		return status.Errorf(codes.InvalidArgument, "value is %d bytes, over the maximum of %d", len(record.Value), max)
+	}
+	if record.Dropped || record.KeyId != 0 || len(record.Sealed) > 0 || record.Codec != 0 ||
+		record.Term != 0 || record.Type != 0 {
+		return status.Error(codes.InvalidArgument, "dropped, key_id, sealed, codec, term and type can't be set by clients")
+	}
+	return nil
+}
+
+func (c *Config) maxKeyBytes() int {
+	if c.MaxKeyBytes == 0 {
+		return defaultMaxKeyBytes
+	}
+	return c.MaxKeyBytes
+}
+
+func (c *Config) maxValueBytes() int {
+	if c.MaxValueBytes == 0 {
+		return defaultMaxValueBytes
+	}
+	return c.MaxValueBytes
+}
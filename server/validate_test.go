@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/michael-diggin/yass/api"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateRecords(t *testing.T) {
+	client, _, teardown := setupTest(t, func(c *Config) {
+		c.MaxKeyBytes = 8
+		c.MaxValueBytes = 16
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "key", Value: []byte(strings.Repeat("v", 16))}})
+	require.NoError(t, err)
+
+	for name, record := range map[string]*api.Record{
+		"missing":    nil,
+		"empty key":  {Value: []byte("v")},
+		"long key":   {Id: strings.Repeat("k", 9)},
+		"long value": {Id: "big", Value: []byte(strings.Repeat("v", 17))},
+		"namespace":  {Namespace: "a/b", Id: "key"},
+		"dropped":    {Namespace: "ns", Id: "key", Dropped: true},
+		"sealed":     {Id: "key", KeyId: 1, Sealed: []byte("s")},
+		"raft":       {Id: "key", Term: 2, Type: 1},
+	} {
+		_, err := client.Set(ctx, &api.SetRequest{Record: record})
+		require.Equal(t, codes.InvalidArgument, status.Code(err), name)
+	}
+	_, err = client.Get(ctx, &api.GetRequest{Id: "big"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Get(ctx, &api.GetRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestValidateKeyCollision(t *testing.T) {
+	client, _, teardown := setupTest(t, nil)
+	defer teardown()
+
+	ctx := context.Background()
+	_, err := client.Set(ctx, &api.SetRequest{Record: &api.Record{Namespace: "team-a", Id: "key", Value: []byte("a")}})
+	require.NoError(t, err)
+
+	// an id holding the separator would be held under team-a's key
+	_, err = client.Set(ctx, &api.SetRequest{Record: &api.Record{Id: "team-a\x00key", Value: []byte("b")}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.Get(ctx, &api.GetRequest{Id: "team-a\x00key"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	resp, err := client.Get(ctx, &api.GetRequest{Namespace: "team-a", Id: "key"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), resp.Record.Value)
+}
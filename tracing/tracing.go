@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+type Config struct {
+	// Exporter receives finished spans, see NewWriterExporter
+	// and NewFileExporter for exporters suited to tests
+	Exporter    sdktrace.SpanExporter
+	ServiceName string
+	NodeName    string
+}
+
+// Tracing owns the process wide tracer provider
+type Tracing struct {
+	provider *sdktrace.TracerProvider
+}
+
+// New installs a tracer provider sending spans to the configured
+// exporter, and the W3C trace context propagator
+func New(config Config) (*Tracing, error) {
+	if config.ServiceName == "" {
+		config.ServiceName = "yass"
+	}
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(config.ServiceName),
+		attribute.String("yass.node", config.NodeName),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(config.Exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return &Tracing{provider: provider}, nil
+}
+
+// Shutdown flushes any buffered spans to the exporter
+func (t *Tracing) Shutdown() error {
+	return t.provider.Shutdown(context.Background())
+}
+
+// NewWriterExporter returns an exporter writing spans to `w` as JSON
+func NewWriterExporter(w io.Writer) (sdktrace.SpanExporter, error) {
+	return stdouttrace.New(stdouttrace.WithWriter(w))
+}
+
+// NewFileExporter returns an exporter appending spans to the file at `path`
+func NewFileExporter(path string) (sdktrace.SpanExporter, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	exp, err := NewWriterExporter(f)
+	if err != nil {
+		return nil, err
+	}
+	return &fileExporter{SpanExporter: exp, file: f}, nil
+}
+
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	if err := e.SpanExporter.Shutdown(ctx); err != nil {
+		return err
+	}
+	return e.file.Close()
+}
@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestFileExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracing-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spans.json")
+
+	exp, err := NewFileExporter(path)
+	require.NoError(t, err)
+	tr, err := New(Config{Exporter: exp, NodeName: "0"})
+	require.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test-span")
+	span.End()
+	require.NoError(t, tr.Shutdown())
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "test-span")
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	// the client's span context reaches the server through metadata
+	parent, span := otel.Tracer("test").Start(context.Background(), "client")
+	defer span.End()
+	var md metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	err := UnaryClientInterceptor()(parent, "/api.Storage/Get", nil, nil, nil, invoker)
+	require.NoError(t, err)
+
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	info := &grpc.UnaryServerInfo{FullMethod: "/api.Storage/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		require.True(t, trace.SpanContextFromContext(ctx).IsValid())
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	_, err = UnaryServerInterceptor()(ctx, nil, info, handler)
+	require.Error(t, err)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "/api.Storage/Get", spans[0].Name())
+	require.Equal(t, span.SpanContext().TraceID(), spans[0].SpanContext().TraceID())
+	require.Equal(t, span.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	require.Equal(t, "not found", spans[0].Status().Description)
+}